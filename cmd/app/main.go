@@ -4,6 +4,7 @@ import (
 	"context"
 	router "gin-quickstart/internal/transport/http"
 	"gin-quickstart/internal/transport/http/handlers"
+	"gin-quickstart/internal/usecases"
 	"gin-quickstart/pkg/graceful_shutdown"
 	httpserver "gin-quickstart/pkg/http_server"
 	"gin-quickstart/pkg/http_server/mw"
@@ -13,7 +14,11 @@ func Run() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	httpHandlers := handlers.NewHTTPHandlers()
+	downloadUseCase := usecases.NewDownloadUseCase(
+		usecases.WithHTTPCache(1024),
+	)
+
+	httpHandlers := handlers.NewHTTPHandlers(downloadUseCase)
 
 	router := router.NewRouter(httpHandlers)
 
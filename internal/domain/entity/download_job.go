@@ -9,6 +9,7 @@ const (
 	Done
 	Failed
 	Canceled
+	Paused
 )
 
 func (s *DownloadJobStatus) String() string {
@@ -21,6 +22,37 @@ func (s *DownloadJobStatus) String() string {
 		return "FAILED"
 	case Canceled:
 		return "CANCELED"
+	case Paused:
+		return "PAUSED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Terminal reports whether the job has ended for good: Done, Failed or Canceled.
+func (s *DownloadJobStatus) Terminal() bool {
+	return *s == Done || *s == Failed || *s == Canceled
+}
+
+type DownloadItemState int
+
+const (
+	ItemPending DownloadItemState = iota
+	ItemDownloading
+	ItemDone
+	ItemFailed
+)
+
+func (s *DownloadItemState) String() string {
+	switch *s {
+	case ItemPending:
+		return "PENDING"
+	case ItemDownloading:
+		return "DOWNLOADING"
+	case ItemDone:
+		return "DONE"
+	case ItemFailed:
+		return "FAILED"
 	default:
 		return "UNKNOWN"
 	}
@@ -31,17 +63,192 @@ type DownloadItemErrorCode string
 const (
 	ErrorTimeout DownloadItemErrorCode = "TIMEOUT"
 	ErrorHTTP    DownloadItemErrorCode = "HTTP_ERROR"
+	ErrorNetwork DownloadItemErrorCode = "NETWORK_ERROR"
 	ErrorUnknown DownloadItemErrorCode = "UNKNOWN"
+
+	ErrorInvalidURL DownloadItemErrorCode = "INVALID_URL"
+
+	ErrorChecksumMismatch DownloadItemErrorCode = "CHECKSUM_MISMATCH"
+	ErrorTooLarge         DownloadItemErrorCode = "TOO_LARGE"
+	ErrorJobQuotaExceeded DownloadItemErrorCode = "JOB_QUOTA_EXCEEDED"
+	ErrorMimeNotAllowed   DownloadItemErrorCode = "MIME_NOT_ALLOWED"
+
+	ErrorBlockedDestination DownloadItemErrorCode = "BLOCKED_DESTINATION"
+	ErrorRedirectNotAllowed DownloadItemErrorCode = "REDIRECT_NOT_ALLOWED"
+	ErrorProxyUnsupported   DownloadItemErrorCode = "PROXY_UNSUPPORTED"
+
+	ErrorExtractFailed   DownloadItemErrorCode = "EXTRACT_FAILED"
+	ErrorUnsafeArchive   DownloadItemErrorCode = "UNSAFE_ARCHIVE"
+	ErrorArchiveTooLarge DownloadItemErrorCode = "ARCHIVE_TOO_LARGE"
 )
 
 type DownloadItemError struct {
 	Code DownloadItemErrorCode
 }
 
+// DownloadAttempt is one try at fetching an item. ErrorCode is empty and
+// StatusCode is 0 when the try succeeded or never got a response.
+type DownloadAttempt struct {
+	StartedAt  time.Time
+	ErrorCode  DownloadItemErrorCode
+	StatusCode int
+}
+
+type DigestAlgorithm string
+
+const (
+	DigestSHA256 DigestAlgorithm = "sha256"
+	DigestSHA512 DigestAlgorithm = "sha512"
+	DigestMD5    DigestAlgorithm = "md5"
+)
+
+// Checksum is the digest a downloaded file is expected to have. Value is hex encoded.
+type Checksum struct {
+	Algorithm DigestAlgorithm
+	Value     string
+}
+
+// BasicAuth are the credentials of HTTP basic authentication.
+type BasicAuth struct {
+	Username string
+	Password Secret
+}
+
+// RequestSettings are the headers and credentials sent to the upstream. Header
+// values are secrets too, since they often carry API keys.
+type RequestSettings struct {
+	Headers     map[string]Secret
+	BasicAuth   *BasicAuth
+	BearerToken Secret
+}
+
+// DownloadSource is one file requested in a job.
+type DownloadSource struct {
+	URL string
+	// Checksum, if set, fails the item when the downloaded file differs.
+	Checksum *Checksum
+	// Request overrides the headers and credentials of the job for this file.
+	Request RequestSettings
+	// Extract unpacks the downloaded zip or tar archive into a file per entry.
+	Extract bool
+}
+
 type DownloadItem struct {
-	URL    string
+	DownloadSource
+	State  DownloadItemState
+	FileID string
+	// SHA256 is the hex digest of the stored file.
+	SHA256   string
+	Error    *DownloadItemError
+	Attempts []DownloadAttempt
+	// BytesReceived counts the bytes downloaded so far; BytesTotal is the
+	// expected size, -1 while it is unknown.
+	BytesReceived int64
+	BytesTotal    int64
+	// Speed is the current download rate in bytes per second while the item
+	// is downloading.
+	Speed int64
+	// CacheHit is set when the file was reused from an earlier download;
+	// Revalidated when the upstream had to confirm it was unchanged first.
+	CacheHit    bool
+	Revalidated bool
+	// Redirects are the hops the last request for the item was redirected
+	// through, refused ones included; FinalURL is where the file came from.
+	Redirects []Redirect
+	FinalURL  string
+	// Proxy is the proxy the last request went through, without credentials;
+	// empty when it connected directly.
+	Proxy string
+	// Children are the files extracted from the archive of the item.
+	Children []ExtractedFile
+}
+
+// ExtractedFile is an entry of an extracted archive, stored as its own file.
+// Path is the name of the entry inside the archive.
+type ExtractedFile struct {
+	Path   string
 	FileID string
-	Error  *DownloadItemError
+	SHA256 string
+	Size   int64
+}
+
+// Redirect is one hop of a redirect chain: the upstream answered StatusCode
+// and pointed to URL.
+type Redirect struct {
+	URL        string
+	StatusCode int
+}
+
+// RedirectPolicy decides which redirects a download follows.
+type RedirectPolicy struct {
+	MaxRedirects int
+	// AllowDowngrade follows redirects from https to http.
+	AllowDowngrade bool
+	// AllowCrossHost follows redirects to a host other than the one of the
+	// requested URL.
+	AllowCrossHost bool
+}
+
+// RetryPolicy decides whether and when a failed item is tried again. Upstream
+// responses are retried by status code, every other failure by error code.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Jitter is the fraction of each backoff, between 0 and 1, that is
+	// randomised. A job policy without one keeps the jitter of the service.
+	Jitter               *float64
+	RetryableErrors      []DownloadItemErrorCode
+	RetryableStatusCodes []int
+}
+
+// Callback is where the final state of a job is posted once it ends.
+type Callback struct {
+	URL string
+	// Secret signs the posted payload; an empty secret leaves it unsigned.
+	Secret Secret
+}
+
+// WebhookDelivery is one attempt at posting a job to its callback. Error is
+// empty when the callback accepted the job.
+type WebhookDelivery struct {
+	At         time.Time
+	JobStatus  DownloadJobStatus
+	StatusCode int
+	Error      string
+}
+
+// DownloadOptions tune how the items of a job are fetched and reported.
+type DownloadOptions struct {
+	// Segments is the number of parallel byte ranges a large file is split
+	// into; values below 2 disable segmented downloads.
+	Segments       int
+	MinSegmentSize int64
+	// RetryPolicy overrides the non-zero fields of the service defaults.
+	RetryPolicy *RetryPolicy
+	// Request are the headers and credentials sent for every file of the job.
+	Request RequestSettings
+	// Proxy is the URL of a proxy that replaces the proxy routes of the
+	// service for the job. It may carry credentials.
+	Proxy Secret
+	// RedirectPolicy replaces the service default when set.
+	RedirectPolicy *RedirectPolicy
+	// MaxBandwidth caps the job in bytes per second; zero leaves it to its
+	// fair share of the service-wide limit.
+	MaxBandwidth int64
+	// Callback, if set, receives the job once it is Done, Failed or Canceled.
+	Callback *Callback
+	// BypassCache downloads every file again; the cache is still refreshed
+	// with the result.
+	BypassCache bool
+	// MaxFileSize and MaxJobBytes lower the service limits on the size of a
+	// file and on the total bytes of the job; zero keeps the service limits.
+	MaxFileSize int64
+	MaxJobBytes int64
+	// AllowedMimeTypes, when not empty, and DeniedMimeTypes filter files by
+	// media type; patterns such as "image/*" match a whole type.
+	AllowedMimeTypes []string
+	DeniedMimeTypes  []string
 }
 
 type DownloadJob struct {
@@ -50,5 +257,12 @@ type DownloadJob struct {
 	UpdatedAt time.Time
 	Timeout   time.Duration
 	Status    DownloadJobStatus
-	Items     []DownloadItem
+	Options   DownloadOptions
+	// Items hold one entry per requested URL, in the order of the request.
+	Items []DownloadItem
+	// Throughput is the current download rate in bytes per second; it is
+	// only measured while the job runs.
+	Throughput int64
+	// Deliveries logs the attempts at posting the job to its callback.
+	Deliveries []WebhookDelivery
 }
@@ -1,12 +1,32 @@
 package entity
 
-type FileMetadata struct {
-	ID       string
-	MimeType string
-	Size     int64
-}
+import "time"
 
-type File struct {
-	Metadata FileMetadata
-	Data     []byte
+type FileMetadata struct {
+	ID string
+	// MimeType is the type the file is served as: DeclaredMimeType, the type
+	// the upstream announced, unless it is missing or generic, in which case
+	// DetectedMimeType, the type sniffed from the content.
+	MimeType         string
+	DeclaredMimeType string
+	DetectedMimeType string
+	// Filename is a name safe to save the file as, without any directory.
+	Filename string
+	// Size is the number of bytes stored.
+	Size int64
+	// SHA256 is the hex digest of the content.
+	SHA256 string
+	// SourceURL is the URL the file was requested from and FinalURL the one it
+	// came from after redirects, both without credentials.
+	SourceURL string
+	FinalURL  string
+	// ETag and LastModified are the validators the upstream sent with the file.
+	ETag         string
+	LastModified string
+	// Headers are the kept upstream response headers, by canonical name.
+	Headers map[string]string
+	// StartedAt is when the first stored byte was requested, FinishedAt when
+	// the last one arrived.
+	StartedAt  time.Time
+	FinishedAt time.Time
 }
@@ -0,0 +1,34 @@
+package entity
+
+import "time"
+
+type JobEventType string
+
+const (
+	EventJobStatus       JobEventType = "job_status"
+	EventItemStarted     JobEventType = "item_started"
+	EventItemProgress    JobEventType = "item_progress"
+	EventItemDone        JobEventType = "item_done"
+	EventItemFailed      JobEventType = "item_failed"
+	EventItemInterrupted JobEventType = "item_interrupted"
+)
+
+// JobEvent is a change of a job as it happens. IDs grow across all jobs, so
+// a client that reconnects can ask for the events after the last one it saw.
+type JobEvent struct {
+	ID    int64
+	JobID string
+	Type  JobEventType
+	At    time.Time
+	// Status is set on EventJobStatus.
+	Status DownloadJobStatus
+	// Index and Item are set on item events; Index is the position of the
+	// item in the job.
+	Index int
+	Item  DownloadItem
+}
+
+// Terminal reports whether no more events follow e.
+func (e JobEvent) Terminal() bool {
+	return e.Type == EventJobStatus && e.Status.Terminal()
+}
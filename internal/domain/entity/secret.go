@@ -0,0 +1,35 @@
+package entity
+
+import (
+	"encoding/json"
+	"log/slog"
+)
+
+const redacted = "[REDACTED]"
+
+// Secret is a credential. It prints, logs and marshals as [REDACTED]; only
+// Reveal returns the value, for the request that needs it.
+type Secret string
+
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
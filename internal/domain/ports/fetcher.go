@@ -0,0 +1,77 @@
+package ports
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrTemporary is wrapped by fetcher errors worth retrying that are neither
+// network errors nor upstream statuses, such as transient FTP replies.
+var ErrTemporary = errors.New("temporary upstream failure")
+
+// ErrInvalidURL is returned for a URL a fetcher refuses to request at all,
+// such as one that would smuggle commands into the protocol.
+var ErrInvalidURL = errors.New("invalid URL")
+
+// UpstreamError is an upstream refusing to serve a file. StatusCode is in the
+// status space of the protocol, RetryAfter how long it asked to wait if it did.
+type UpstreamError struct {
+	Status     string
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("Upstream error: %s %d", e.Status, e.StatusCode)
+}
+
+// FetchRequest asks for the content of URL from Offset on, or only for the
+// Length bytes from Offset when Length is positive.
+type FetchRequest struct {
+	URL    string
+	Offset int64
+	Length int64
+	// Validator is the ETag or modification time of the version Offset refers
+	// to; if the file changed since, the fetcher starts over from zero.
+	Validator string
+	// IfNoneMatch and IfModifiedSince ask for NotModified instead of the
+	// content if the file did not change.
+	IfNoneMatch     string
+	IfModifiedSince string
+	// Header is sent as is by protocols that have request headers.
+	Header http.Header
+}
+
+// FetchResponse is the content of a file being fetched.
+type FetchResponse struct {
+	Body io.ReadCloser
+	// Offset is the position in the file Body starts at; Partial is set when
+	// Body holds the requested range rather than the whole file.
+	Offset  int64
+	Partial bool
+	// Size is the size of the whole file, -1 when unknown.
+	Size        int64
+	ContentType string
+	// Resumable reports whether the upstream serves requests from an offset.
+	Resumable bool
+	// NotModified answers a conditional request whose file did not change;
+	// Body is then empty.
+	NotModified  bool
+	ETag         string
+	LastModified string
+	// StatusCode and Header are the status and metadata in the terms of the
+	// protocol; Header is nil for protocols without headers.
+	StatusCode int
+	Header     http.Header
+	// URL is where the content came from, without credentials.
+	URL string
+}
+
+// Fetcher retrieves files for the URL schemes it is registered for.
+type Fetcher interface {
+	Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error)
+}
@@ -3,10 +3,23 @@ package ports
 import (
 	"context"
 	"gin-quickstart/internal/domain/entity"
+	"io"
 )
 
+// FileWriter streams the content of a new file into storage. Nothing is
+// visible to readers until Commit succeeds; Abort discards the written bytes.
+type FileWriter interface {
+	io.Writer
+	Commit(ctx context.Context, metadata entity.FileMetadata) (entity.FileMetadata, error)
+	Abort() error
+}
+
 type FileRepository interface {
-	Create(ctx context.Context, file entity.File) (string, error)
-	Get(ctx context.Context, fileID string) (entity.File, error)
+	Create(ctx context.Context) (FileWriter, error)
+	Open(ctx context.Context, fileID string) (io.ReadSeekCloser, entity.FileMetadata, error)
 	Metadata(ctx context.Context, fileID string) (entity.FileMetadata, error)
+	// Clone adds a new file sharing the content and metadata of fileID.
+	Clone(ctx context.Context, fileID string) (entity.FileMetadata, error)
+	// Delete removes fileID; the content goes once no other file shares it.
+	Delete(ctx context.Context, fileID string) error
 }
@@ -7,11 +7,80 @@ package mocks
 import (
 	context "context"
 	entity "gin-quickstart/internal/domain/entity"
+	ports "gin-quickstart/internal/domain/ports"
+	io "io"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
 )
 
+// MockFileWriter is a mock of FileWriter interface.
+type MockFileWriter struct {
+	ctrl     *gomock.Controller
+	recorder *MockFileWriterMockRecorder
+}
+
+// MockFileWriterMockRecorder is the mock recorder for MockFileWriter.
+type MockFileWriterMockRecorder struct {
+	mock *MockFileWriter
+}
+
+// NewMockFileWriter creates a new mock instance.
+func NewMockFileWriter(ctrl *gomock.Controller) *MockFileWriter {
+	mock := &MockFileWriter{ctrl: ctrl}
+	mock.recorder = &MockFileWriterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockFileWriter) EXPECT() *MockFileWriterMockRecorder {
+	return m.recorder
+}
+
+// Abort mocks base method.
+func (m *MockFileWriter) Abort() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Abort")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Abort indicates an expected call of Abort.
+func (mr *MockFileWriterMockRecorder) Abort() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Abort", reflect.TypeOf((*MockFileWriter)(nil).Abort))
+}
+
+// Commit mocks base method.
+func (m *MockFileWriter) Commit(ctx context.Context, metadata entity.FileMetadata) (entity.FileMetadata, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Commit", ctx, metadata)
+	ret0, _ := ret[0].(entity.FileMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Commit indicates an expected call of Commit.
+func (mr *MockFileWriterMockRecorder) Commit(ctx, metadata interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Commit", reflect.TypeOf((*MockFileWriter)(nil).Commit), ctx, metadata)
+}
+
+// Write mocks base method.
+func (m *MockFileWriter) Write(p []byte) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Write", p)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Write indicates an expected call of Write.
+func (mr *MockFileWriterMockRecorder) Write(p interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Write", reflect.TypeOf((*MockFileWriter)(nil).Write), p)
+}
+
 // MockFileRepository is a mock of FileRepository interface.
 type MockFileRepository struct {
 	ctrl     *gomock.Controller
@@ -35,34 +104,48 @@ func (m *MockFileRepository) EXPECT() *MockFileRepositoryMockRecorder {
 	return m.recorder
 }
 
-// Create mocks base method.
-func (m *MockFileRepository) Create(ctx context.Context, file entity.File) (string, error) {
+// Clone mocks base method.
+func (m *MockFileRepository) Clone(ctx context.Context, fileID string) (entity.FileMetadata, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Create", ctx, file)
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "Clone", ctx, fileID)
+	ret0, _ := ret[0].(entity.FileMetadata)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Create indicates an expected call of Create.
-func (mr *MockFileRepositoryMockRecorder) Create(ctx, file interface{}) *gomock.Call {
+// Clone indicates an expected call of Clone.
+func (mr *MockFileRepositoryMockRecorder) Clone(ctx, fileID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockFileRepository)(nil).Create), ctx, file)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Clone", reflect.TypeOf((*MockFileRepository)(nil).Clone), ctx, fileID)
 }
 
-// Get mocks base method.
-func (m *MockFileRepository) Get(ctx context.Context, fileID string) (entity.File, error) {
+// Create mocks base method.
+func (m *MockFileRepository) Create(ctx context.Context) (ports.FileWriter, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Get", ctx, fileID)
-	ret0, _ := ret[0].(entity.File)
+	ret := m.ctrl.Call(m, "Create", ctx)
+	ret0, _ := ret[0].(ports.FileWriter)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Get indicates an expected call of Get.
-func (mr *MockFileRepositoryMockRecorder) Get(ctx, fileID interface{}) *gomock.Call {
+// Create indicates an expected call of Create.
+func (mr *MockFileRepositoryMockRecorder) Create(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockFileRepository)(nil).Create), ctx)
+}
+
+// Delete mocks base method.
+func (m *MockFileRepository) Delete(ctx context.Context, fileID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, fileID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockFileRepositoryMockRecorder) Delete(ctx, fileID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockFileRepository)(nil).Get), ctx, fileID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockFileRepository)(nil).Delete), ctx, fileID)
 }
 
 // Metadata mocks base method.
@@ -79,3 +162,19 @@ func (mr *MockFileRepositoryMockRecorder) Metadata(ctx, fileID interface{}) *gom
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Metadata", reflect.TypeOf((*MockFileRepository)(nil).Metadata), ctx, fileID)
 }
+
+// Open mocks base method.
+func (m *MockFileRepository) Open(ctx context.Context, fileID string) (io.ReadSeekCloser, entity.FileMetadata, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Open", ctx, fileID)
+	ret0, _ := ret[0].(io.ReadSeekCloser)
+	ret1, _ := ret[1].(entity.FileMetadata)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Open indicates an expected call of Open.
+func (mr *MockFileRepositoryMockRecorder) Open(ctx, fileID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Open", reflect.TypeOf((*MockFileRepository)(nil).Open), ctx, fileID)
+}
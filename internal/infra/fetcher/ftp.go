@@ -0,0 +1,348 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"gin-quickstart/internal/domain/ports"
+	"io"
+	"mime"
+	"net"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultFTPPort  = "21"
+	defaultFTPSPort = "990"
+)
+
+var errPassiveReply = errors.New("unexpected passive mode reply")
+
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// FTPFetcher fetches ftp and ftps URLs in passive mode, logging in with the
+// credentials of the URL or anonymously. ftps uses implicit TLS on both the
+// control and the data connection.
+type FTPFetcher struct {
+	dial      DialFunc
+	tlsConfig *tls.Config
+}
+
+type FTPOption func(*FTPFetcher)
+
+// WithFTPDialer replaces the dialer of the control and data connections.
+func WithFTPDialer(dial DialFunc) FTPOption {
+	return func(f *FTPFetcher) {
+		f.dial = dial
+	}
+}
+
+func WithFTPTLSConfig(config *tls.Config) FTPOption {
+	return func(f *FTPFetcher) {
+		f.tlsConfig = config
+	}
+}
+
+func NewFTPFetcher(options ...FTPOption) *FTPFetcher {
+	f := &FTPFetcher{
+		dial:      (&net.Dialer{}).DialContext,
+		tlsConfig: &tls.Config{},
+	}
+
+	for _, opt := range options {
+		opt(f)
+	}
+
+	// data connections resume the TLS session of the control connection,
+	// which many servers require
+	f.tlsConfig = f.tlsConfig.Clone()
+	if f.tlsConfig.ClientSessionCache == nil {
+		f.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+
+	return f
+}
+
+// replyError turns an unexpected reply into a temporary failure for 4xx
+// codes and an upstream error for the permanent 5xx ones.
+func replyError(err error) error {
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) {
+		return err
+	}
+	if protoErr.Code >= 400 && protoErr.Code < 500 {
+		return fmt.Errorf("%w: %d %s", ports.ErrTemporary, protoErr.Code, protoErr.Msg)
+	}
+	return &ports.UpstreamError{Status: protoErr.Msg, StatusCode: protoErr.Code}
+}
+
+type ftpConn struct {
+	*textproto.Conn
+	conn   net.Conn
+	secure bool
+	config *tls.Config
+}
+
+func (c *ftpConn) cmd(expectCode int, format string, args ...any) (int, string, error) {
+	if _, err := c.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	code, msg, err := c.ReadResponse(expectCode)
+	if err != nil {
+		return code, msg, replyError(err)
+	}
+	return code, msg, nil
+}
+
+func (f *FTPFetcher) connect(ctx context.Context, u *url.URL) (*ftpConn, error) {
+	secure := u.Scheme == "ftps"
+	port := u.Port()
+	if port == "" {
+		port = defaultFTPPort
+		if secure {
+			port = defaultFTPSPort
+		}
+	}
+
+	conn, err := f.dial(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+
+	config := f.tlsConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName = u.Hostname()
+	}
+	if secure {
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	c := &ftpConn{Conn: textproto.NewConn(conn), conn: conn, secure: secure, config: config}
+	if _, _, err := c.ReadResponse(220); err != nil {
+		_ = c.Close()
+		return nil, replyError(err)
+	}
+
+	username, password := "anonymous", "anonymous@"
+	if u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	code, _, err := c.cmd(0, "USER %s", username)
+	if err == nil && code == 331 {
+		_, _, err = c.cmd(230, "PASS %s", password)
+	} else if err == nil && code != 230 {
+		err = &ports.UpstreamError{Status: "login refused", StatusCode: code}
+	}
+	if err == nil && secure {
+		if _, _, err = c.cmd(200, "PBSZ 0"); err == nil {
+			_, _, err = c.cmd(200, "PROT P")
+		}
+	}
+	if err == nil {
+		_, _, err = c.cmd(200, "TYPE I")
+	}
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// passive opens a data connection. It always connects to the address of the
+// control connection: the address in a PASV reply is neither trusted nor,
+// behind NAT, reachable.
+func (f *FTPFetcher) passive(ctx context.Context, c *ftpConn) (net.Conn, error) {
+	var port string
+	if _, msg, err := c.cmd(229, "EPSV"); err == nil {
+		// 229 Entering Extended Passive Mode (|||port|)
+		_, rest, _ := strings.Cut(msg, "(|||")
+		port, _, _ = strings.Cut(rest, "|)")
+	} else if _, msg, err := c.cmd(227, "PASV"); err == nil {
+		// 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+		_, rest, _ := strings.Cut(msg, "(")
+		rest, _, _ = strings.Cut(rest, ")")
+		fields := strings.Split(rest, ",")
+		if len(fields) == 6 {
+			p1, err1 := strconv.Atoi(fields[4])
+			p2, err2 := strconv.Atoi(fields[5])
+			if err1 == nil && err2 == nil {
+				port = strconv.Itoa(p1<<8 | p2)
+			}
+		}
+	} else {
+		return nil, err
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return nil, errPassiveReply
+	}
+
+	host, _, err := net.SplitHostPort(c.conn.RemoteAddr().String())
+	if err != nil {
+		return nil, err
+	}
+	conn, err := f.dial(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	if c.secure {
+		tlsConn := tls.Client(conn, c.config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	return conn, nil
+}
+
+// checkCommandArgs rejects the path and credentials of u that would end an
+// FTP command early and send what follows as commands of their own.
+func checkCommandArgs(u *url.URL, filePath string) error {
+	args := []string{filePath}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		args = append(args, u.User.Username(), password)
+	}
+	for _, arg := range args {
+		if strings.ContainsAny(arg, "\r\n\x00") {
+			return fmt.Errorf("%w: control character in FTP path or credentials", ports.ErrInvalidURL)
+		}
+	}
+	return nil
+}
+
+func (f *FTPFetcher) Fetch(ctx context.Context, req ports.FetchRequest) (*ports.FetchResponse, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	filePath := strings.TrimPrefix(u.Path, "/")
+	if err := checkCommandArgs(u, filePath); err != nil {
+		return nil, err
+	}
+
+	c, err := f.connect(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	// a canceled request unblocks whatever the connections are waiting for
+	stop := context.AfterFunc(ctx, func() { _ = c.Close() })
+
+	fetched, err := f.retrieve(ctx, c, filePath, req)
+	if err != nil {
+		stop()
+		_ = c.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	fetched.URL = u.Redacted()
+	fetched.Body.(*ftpBody).stop = stop
+	return fetched, nil
+}
+
+func (f *FTPFetcher) retrieve(ctx context.Context, c *ftpConn, filePath string, req ports.FetchRequest) (*ports.FetchResponse, error) {
+	fetched := &ports.FetchResponse{
+		Size:        -1,
+		ContentType: mime.TypeByExtension(path.Ext(filePath)),
+	}
+
+	if _, msg, err := c.cmd(213, "SIZE %s", filePath); err == nil {
+		if size, err := strconv.ParseInt(strings.TrimSpace(msg), 10, 64); err == nil {
+			fetched.Size = size
+		}
+	}
+	if _, msg, err := c.cmd(213, "MDTM %s", filePath); err == nil {
+		fetched.LastModified = strings.TrimSpace(msg)
+	}
+
+	// a changed file is fetched again from the start
+	offset := req.Offset
+	if req.Validator != "" && req.Validator != fetched.LastModified {
+		offset = 0
+	}
+
+	data, err := f.passive(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	// REST tells whether the server resumes at all, even from zero
+	if _, _, err := c.cmd(350, "REST %d", offset); err == nil {
+		fetched.Resumable = true
+	} else if offset > 0 {
+		offset = 0
+	}
+
+	code, _, err := c.cmd(1, "RETR %s", filePath)
+	if err != nil {
+		_ = data.Close()
+		return nil, err
+	}
+
+	body := &ftpBody{conn: c, data: data, reader: data, size: fetched.Size, received: offset}
+	fetched.Partial = fetched.Resumable && (offset > 0 || req.Length > 0)
+	if fetched.Partial && req.Length > 0 {
+		body.reader = io.LimitReader(data, req.Length)
+		body.limited = true
+	}
+	fetched.Body = body
+	fetched.Offset = offset
+	fetched.StatusCode = code
+	return fetched, nil
+}
+
+// ftpBody reads the data connection and checks the transfer completed once
+// it ends. Closing it closes both connections.
+type ftpBody struct {
+	conn     *ftpConn
+	data     net.Conn
+	reader   io.Reader
+	stop     func() bool
+	size     int64
+	received int64
+	// limited bodies end before the file does, so they are not checked.
+	limited bool
+	done    bool
+}
+
+func (b *ftpBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	b.received += int64(n)
+	if err != io.EOF || b.limited || b.done {
+		return n, err
+	}
+
+	b.done = true
+	_ = b.data.Close()
+	if _, _, replyErr := b.conn.ReadResponse(2); replyErr != nil {
+		return n, replyError(replyErr)
+	}
+	if b.size >= 0 && b.received < b.size {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, io.EOF
+}
+
+func (b *ftpBody) Close() error {
+	if b.stop != nil {
+		b.stop()
+	}
+	_ = b.data.Close()
+	if b.done {
+		_, _ = b.conn.Cmd("QUIT")
+	}
+	return b.conn.Close()
+}
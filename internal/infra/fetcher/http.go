@@ -0,0 +1,149 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"gin-quickstart/internal/domain/ports"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPFetcher fetches http and https URLs with client, which decides how
+// requests are routed and redirected.
+type HTTPFetcher struct {
+	client    *http.Client
+	userAgent string
+}
+
+func NewHTTPFetcher(client *http.Client, userAgent string) *HTTPFetcher {
+	return &HTTPFetcher{client: client, userAgent: userAgent}
+}
+
+func (f *HTTPFetcher) Fetch(ctx context.Context, req ports.FetchRequest) (*ports.FetchResponse, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, req.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range req.Header {
+		httpReq.Header[key] = values
+	}
+	if httpReq.Header.Get("User-Agent") == "" {
+		httpReq.Header.Set("User-Agent", f.userAgent)
+	}
+
+	switch {
+	case req.Length > 0:
+		httpReq.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", req.Offset, req.Offset+req.Length-1))
+	case req.Offset > 0:
+		httpReq.Header.Set("Range", fmt.Sprintf("bytes=%d-", req.Offset))
+	}
+	// If-Range makes the upstream answer with the whole file if it changed
+	if req.Validator != "" && httpReq.Header.Get("Range") != "" {
+		httpReq.Header.Set("If-Range", req.Validator)
+	}
+	if req.IfNoneMatch != "" {
+		httpReq.Header.Set("If-None-Match", req.IfNoneMatch)
+	}
+	if req.IfModifiedSince != "" {
+		httpReq.Header.Set("If-Modified-Since", req.IfModifiedSince)
+	}
+
+	resp, err := f.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	fetched := &ports.FetchResponse{
+		Body:         resp.Body,
+		Size:         -1,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header,
+		URL:          resp.Request.URL.Redacted(),
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		fetched.Size = resp.ContentLength
+		fetched.Resumable = resp.Header.Get("Accept-Ranges") == "bytes"
+	case http.StatusPartialContent:
+		fetched.Partial = true
+		fetched.Resumable = true
+		fetched.Size = contentRangeTotal(resp)
+		// an unreadable range never matches the requested offset
+		fetched.Offset = -1
+		if start, err := contentRangeStart(resp); err == nil {
+			fetched.Offset = start
+		}
+	case http.StatusNotModified:
+		_ = resp.Body.Close()
+		fetched.Body = http.NoBody
+		fetched.NotModified = true
+	default:
+		_ = resp.Body.Close()
+		return nil, NewUpstreamError(resp)
+	}
+	return fetched, nil
+}
+
+func NewUpstreamError(resp *http.Response) *ports.UpstreamError {
+	return &ports.UpstreamError{
+		Status:     resp.Status,
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp),
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+// contentRangeStart returns the first byte position of a
+// "Content-Range: bytes first-last/total" header.
+func contentRangeStart(resp *http.Response) (int64, error) {
+	cr := resp.Header.Get("Content-Range")
+	spec, ok := strings.CutPrefix(cr, "bytes ")
+	if !ok {
+		return 0, fmt.Errorf("invalid Content-Range %q", cr)
+	}
+	first, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, fmt.Errorf("invalid Content-Range %q", cr)
+	}
+	return strconv.ParseInt(first, 10, 64)
+}
+
+// contentRangeTotal returns the complete length of a
+// "Content-Range: bytes first-last/total" header, or -1 if it is unknown.
+func contentRangeTotal(resp *http.Response) int64 {
+	cr := resp.Header.Get("Content-Range")
+	_, total, ok := strings.Cut(cr, "/")
+	if !ok || total == "*" {
+		return -1
+	}
+	n, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
@@ -1,51 +1,124 @@
 package repository
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"gin-quickstart/internal/domain/entity"
+	"gin-quickstart/internal/domain/ports"
+	"hash"
+	"io"
 	"sync"
 
 	"github.com/google/uuid"
 )
 
+var errWriterClosed = errors.New("file writer is already committed or aborted")
+
+// blob is content stored once, however many files share it. Its data is
+// immutable, so readers share it without copying.
+type blob struct {
+	data []byte
+	refs int
+}
+
+type memoryFile struct {
+	metadata entity.FileMetadata
+	// digest is the sha256 of the content, the key of its blob.
+	digest string
+}
+
+// FileMemoryRepository stores content addressed by its sha256: files with the
+// same bytes get their own ID and metadata but share one reference counted blob.
 type FileMemoryRepository struct {
 	mu    sync.RWMutex
-	files map[string]entity.File
+	files map[string]memoryFile
+	blobs map[string]*blob
 }
 
 func NewFileMemoryRepository() *FileMemoryRepository {
-	return &FileMemoryRepository{files: make(map[string]entity.File)}
+	return &FileMemoryRepository{
+		files: make(map[string]memoryFile),
+		blobs: make(map[string]*blob),
+	}
 }
 
-func cloneFile(f entity.File) entity.File {
-	f.Data = append([]byte(nil), f.Data...)
-	return f
+type memoryFileWriter struct {
+	repo   *FileMemoryRepository
+	buf    bytes.Buffer
+	hash   hash.Hash
+	closed bool
 }
 
-func (m *FileMemoryRepository) Create(ctx context.Context, file entity.File) (string, error) {
+func (w *memoryFileWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errWriterClosed
+	}
+	n, err := w.buf.Write(p)
+	w.hash.Write(p[:n])
+	return n, err
+}
+
+func (w *memoryFileWriter) Commit(ctx context.Context, metadata entity.FileMetadata) (entity.FileMetadata, error) {
+	if w.closed {
+		return entity.FileMetadata{}, errWriterClosed
+	}
 	if err := ctx.Err(); err != nil {
-		return "", err
+		return entity.FileMetadata{}, err
 	}
+	w.closed = true
 
 	id := uuid.New().String()
+	digest := hex.EncodeToString(w.hash.Sum(nil))
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	w.repo.mu.Lock()
+	defer w.repo.mu.Unlock()
 
-	if _, exists := m.files[id]; exists {
-		return "", fmt.Errorf("CREATE: File with ID %s already exists", id)
+	if _, exists := w.repo.files[id]; exists {
+		return entity.FileMetadata{}, fmt.Errorf("CREATE: File with ID %s already exists", id)
 	}
 
-	file.Metadata.ID = id
+	b, exists := w.repo.blobs[digest]
+	if !exists {
+		b = &blob{data: w.buf.Bytes()}
+		w.repo.blobs[digest] = b
+	}
+	b.refs++
+	w.buf = bytes.Buffer{}
+
+	metadata.ID = id
+	metadata.Size = int64(len(b.data))
+	metadata.SHA256 = digest
+
+	w.repo.files[id] = memoryFile{metadata: metadata, digest: digest}
+	return metadata, nil
+}
+
+func (w *memoryFileWriter) Abort() error {
+	w.closed = true
+	w.buf = bytes.Buffer{}
+	return nil
+}
+
+func (m *FileMemoryRepository) Create(ctx context.Context) (ports.FileWriter, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &memoryFileWriter{repo: m, hash: sha256.New()}, nil
+}
 
-	m.files[id] = cloneFile(file)
-	return id, nil
+type nopReadSeekCloser struct {
+	*bytes.Reader
 }
 
-func (m *FileMemoryRepository) Get(ctx context.Context, fileID string) (entity.File, error) {
+func (nopReadSeekCloser) Close() error { return nil }
+
+func (m *FileMemoryRepository) Open(ctx context.Context, fileID string) (io.ReadSeekCloser, entity.FileMetadata, error) {
 	if err := ctx.Err(); err != nil {
-		return entity.File{}, err
+		return nil, entity.FileMetadata{}, err
 	}
 
 	m.mu.RLock()
@@ -53,9 +126,9 @@ func (m *FileMemoryRepository) Get(ctx context.Context, fileID string) (entity.F
 
 	file, exists := m.files[fileID]
 	if !exists {
-		return entity.File{}, fmt.Errorf("file not found for id: %s", fileID)
+		return nil, entity.FileMetadata{}, fmt.Errorf("file not found for id: %s", fileID)
 	}
-	return cloneFile(file), nil
+	return nopReadSeekCloser{bytes.NewReader(m.blobs[file.digest].data)}, file.metadata, nil
 }
 
 func (m *FileMemoryRepository) Metadata(ctx context.Context, fileID string) (entity.FileMetadata, error) {
@@ -66,9 +139,56 @@ func (m *FileMemoryRepository) Metadata(ctx context.Context, fileID string) (ent
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	files, exists := m.files[fileID]
+	file, exists := m.files[fileID]
 	if !exists {
 		return entity.FileMetadata{}, fmt.Errorf("download job with id %s not found", fileID)
 	}
-	return files.Metadata, nil
+	return file.metadata, nil
+}
+
+func (m *FileMemoryRepository) Clone(ctx context.Context, fileID string) (entity.FileMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return entity.FileMetadata{}, err
+	}
+
+	id := uuid.New().String()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, exists := m.files[fileID]
+	if !exists {
+		return entity.FileMetadata{}, fmt.Errorf("file not found for id: %s", fileID)
+	}
+	if _, exists := m.files[id]; exists {
+		return entity.FileMetadata{}, fmt.Errorf("CREATE: File with ID %s already exists", id)
+	}
+
+	m.blobs[file.digest].refs++
+
+	file.metadata.ID = id
+	m.files[id] = file
+	return file.metadata, nil
+}
+
+func (m *FileMemoryRepository) Delete(ctx context.Context, fileID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, exists := m.files[fileID]
+	if !exists {
+		return fmt.Errorf("DELETE: File with ID %s not found", fileID)
+	}
+	delete(m.files, fileID)
+
+	if b := m.blobs[file.digest]; b.refs > 1 {
+		b.refs--
+	} else {
+		delete(m.blobs, file.digest)
+	}
+	return nil
 }
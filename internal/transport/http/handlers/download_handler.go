@@ -1,27 +1,311 @@
 package handlers
 
 import (
-	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"gin-quickstart/internal/domain/entity"
+	"gin-quickstart/internal/usecases"
 	pkgerrors "gin-quickstart/pkg/errors"
-	"io"
+	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	validation "github.com/go-ozzo/ozzo-validation"
 )
 
+type checksumReq struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+type basicAuthReq struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// requestSettingsReq are the headers and credentials of a file or, as
+// defaults, of a whole job.
+type requestSettingsReq struct {
+	Headers     map[string]string `json:"headers"`
+	BasicAuth   *basicAuthReq     `json:"basic_auth"`
+	BearerToken string            `json:"bearer_token"`
+}
+
 type File struct {
-	URL string `json:"url"`
+	URL      string       `json:"url"`
+	Checksum *checksumReq `json:"checksum"`
+	Extract  bool         `json:"extract"`
+	requestSettingsReq
+}
+
+var digestSizes = map[string]int{
+	string(entity.DigestSHA256): sha256.Size,
+	string(entity.DigestSHA512): sha512.Size,
+	string(entity.DigestMD5):    md5.Size,
+}
+
+func (req *checksumReq) Validate() error {
+	return validation.ValidateStruct(req,
+		validation.Field(&req.Algorithm, validation.Required, validation.In(
+			string(entity.DigestSHA256),
+			string(entity.DigestSHA512),
+			string(entity.DigestMD5),
+		)),
+		validation.Field(&req.Value, validation.Required, validation.By(func(interface{}) error {
+			b, err := hex.DecodeString(req.Value)
+			if err != nil {
+				return errors.New("must be hex encoded")
+			}
+			if size, known := digestSizes[req.Algorithm]; known && len(b) != size {
+				return fmt.Errorf("must be %d hex characters for %s", 2*size, req.Algorithm)
+			}
+			return nil
+		})),
+	)
+}
+
+var headerName = regexp.MustCompile("^[!#$%&'*+\\-.^_`|~0-9A-Za-z]+$")
+
+// reservedHeaders are set by the downloader itself.
+var reservedHeaders = []string{
+	"Host", "Connection", "Content-Length", "Transfer-Encoding",
+	"Range", "If-Range", "If-None-Match", "If-Modified-Since",
+}
+
+func (req *basicAuthReq) Validate() error {
+	return validation.ValidateStruct(req,
+		validation.Field(&req.Username, validation.Required),
+	)
+}
+
+func (req requestSettingsReq) Validate() error {
+	return validation.ValidateStruct(&req,
+		validation.Field(&req.Headers, validation.By(func(interface{}) error {
+			for name := range req.Headers {
+				if !headerName.MatchString(name) {
+					return fmt.Errorf("%q is not a valid header name", name)
+				}
+				if slices.Contains(reservedHeaders, http.CanonicalHeaderKey(name)) {
+					return fmt.Errorf("%s cannot be set", http.CanonicalHeaderKey(name))
+				}
+			}
+			return nil
+		})),
+		validation.Field(&req.BasicAuth),
+		validation.Field(&req.BearerToken, validation.By(func(interface{}) error {
+			if req.BearerToken != "" && req.BasicAuth != nil {
+				return errors.New("cannot be combined with basic_auth")
+			}
+			return nil
+		})),
+	)
+}
+
+// toEntity expects a validated request.
+func (req *requestSettingsReq) toEntity() entity.RequestSettings {
+	settings := entity.RequestSettings{BearerToken: entity.Secret(req.BearerToken)}
+	if len(req.Headers) > 0 {
+		settings.Headers = make(map[string]entity.Secret, len(req.Headers))
+		for name, value := range req.Headers {
+			settings.Headers[http.CanonicalHeaderKey(name)] = entity.Secret(value)
+		}
+	}
+	if req.BasicAuth != nil {
+		settings.BasicAuth = &entity.BasicAuth{
+			Username: req.BasicAuth.Username,
+			Password: entity.Secret(req.BasicAuth.Password),
+		}
+	}
+	return settings
+}
+
+func (f File) Validate() error {
+	return validation.ValidateStruct(&f,
+		validation.Field(&f.URL, validation.Required),
+		validation.Field(&f.Checksum),
+		validation.Field(&f.requestSettingsReq),
+	)
+}
+
+// toEntity expects a validated request.
+func (f File) toEntity() entity.DownloadSource {
+	source := entity.DownloadSource{URL: f.URL, Extract: f.Extract, Request: f.requestSettingsReq.toEntity()}
+	if f.Checksum != nil {
+		source.Checksum = &entity.Checksum{
+			Algorithm: entity.DigestAlgorithm(f.Checksum.Algorithm),
+			Value:     strings.ToLower(f.Checksum.Value),
+		}
+	}
+	return source
+}
+
+// retryPolicyReq leaves the fields it omits at the service defaults. A zero
+// max_attempts, base_backoff or max_backoff counts as omitted: a backoff
+// without a cap would let an upstream hold a job for as long as it likes. A
+// zero jitter turns jitter off.
+type retryPolicyReq struct {
+	MaxAttempts   int      `json:"max_attempts"`
+	BaseBackoff   string   `json:"base_backoff"`
+	MaxBackoff    string   `json:"max_backoff"`
+	Jitter        *float64 `json:"jitter"`
+	RetryOnErrors []string `json:"retry_on_errors"`
+	RetryOnStatus []int    `json:"retry_on_status"`
+}
+
+// redirectPolicyReq leaves the fields it omits at the service defaults.
+type redirectPolicyReq struct {
+	MaxRedirects   *int  `json:"max_redirects"`
+	AllowDowngrade *bool `json:"allow_downgrade"`
+	AllowCrossHost *bool `json:"allow_cross_host"`
 }
 
 type createDownloadJobReq struct {
-	Files   []File `json:"files"`
-	Timeout string `json:"timeout"`
+	Files            []File             `json:"files"`
+	Timeout          string             `json:"timeout"`
+	Segments         int                `json:"segments"`
+	MinSegmentSize   int64              `json:"min_segment_size"`
+	Retry            *retryPolicyReq    `json:"retry"`
+	Redirect         *redirectPolicyReq `json:"redirect"`
+	MaxBandwidth     int64              `json:"max_bandwidth"`
+	CallbackURL      string             `json:"callback_url"`
+	CallbackSecret   string             `json:"callback_secret"`
+	BypassCache      bool               `json:"bypass_cache"`
+	MaxFileSize      int64              `json:"max_file_size"`
+	MaxJobBytes      int64              `json:"max_job_bytes"`
+	AllowedMimeTypes []string           `json:"allowed_mime_types"`
+	DeniedMimeTypes  []string           `json:"denied_mime_types"`
+	Proxy            string             `json:"proxy"`
+	requestSettingsReq
+}
+
+var isDuration = validation.By(func(value interface{}) error {
+	s, _ := value.(string)
+	if s == "" {
+		return nil
+	}
+	_, err := time.ParseDuration(s)
+	return err
+})
+
+func (req *retryPolicyReq) Validate() error {
+	return validation.ValidateStruct(req,
+		validation.Field(&req.MaxAttempts, validation.Min(0), validation.Max(10)),
+		validation.Field(&req.BaseBackoff, isDuration),
+		validation.Field(&req.MaxBackoff, isDuration),
+		validation.Field(&req.Jitter, validation.Min(0.0), validation.Max(1.0)),
+		validation.Field(&req.RetryOnErrors, validation.Each(validation.In(
+			string(entity.ErrorTimeout),
+			string(entity.ErrorHTTP),
+			string(entity.ErrorNetwork),
+			string(entity.ErrorUnknown),
+			string(entity.ErrorChecksumMismatch),
+			string(entity.ErrorTooLarge),
+			string(entity.ErrorJobQuotaExceeded),
+			string(entity.ErrorMimeNotAllowed),
+			string(entity.ErrorBlockedDestination),
+			string(entity.ErrorRedirectNotAllowed),
+		))),
+		validation.Field(&req.RetryOnStatus, validation.Each(validation.Min(100), validation.Max(599))),
+	)
+}
+
+func (req *redirectPolicyReq) Validate() error {
+	return validation.ValidateStruct(req,
+		validation.Field(&req.MaxRedirects, validation.Min(0), validation.Max(20)),
+	)
+}
+
+var isHTTPURL = validation.By(func(value interface{}) error {
+	s, _ := value.(string)
+	if s == "" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be an absolute http or https URL")
+	}
+	return nil
+})
+
+var isProxyURL = validation.By(func(value interface{}) error {
+	s, _ := value.(string)
+	if s == "" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return errors.New("must be a valid URL")
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return errors.New("must be an http, https, socks5 or socks5h URL")
+	}
+	if u.Hostname() == "" {
+		return errors.New("must have a host")
+	}
+	return nil
+})
+
+var isMimePattern = validation.By(func(value interface{}) error {
+	s, _ := value.(string)
+	if _, _, err := mime.ParseMediaType(s); err != nil || !strings.Contains(s, "/") {
+		return errors.New("must be a media type such as image/png or image/*")
+	}
+	return nil
+})
+
+// toEntity expects a validated request.
+func (req *retryPolicyReq) toEntity() *entity.RetryPolicy {
+	if req == nil {
+		return nil
+	}
+
+	policy := &entity.RetryPolicy{
+		MaxAttempts:          req.MaxAttempts,
+		Jitter:               req.Jitter,
+		RetryableStatusCodes: req.RetryOnStatus,
+	}
+	policy.BaseBackoff, _ = time.ParseDuration(req.BaseBackoff)
+	policy.MaxBackoff, _ = time.ParseDuration(req.MaxBackoff)
+	for _, code := range req.RetryOnErrors {
+		policy.RetryableErrors = append(policy.RetryableErrors, entity.DownloadItemErrorCode(code))
+	}
+	return policy
+}
+
+func (req *redirectPolicyReq) toEntity() *entity.RedirectPolicy {
+	if req == nil {
+		return nil
+	}
+
+	policy := usecases.DefaultRedirectPolicy
+	if req.MaxRedirects != nil {
+		policy.MaxRedirects = *req.MaxRedirects
+	}
+	if req.AllowDowngrade != nil {
+		policy.AllowDowngrade = *req.AllowDowngrade
+	}
+	if req.AllowCrossHost != nil {
+		policy.AllowCrossHost = *req.AllowCrossHost
+	}
+	return &policy
 }
 
 type createDownloadJobResp struct {
@@ -33,6 +317,24 @@ func (req *createDownloadJobReq) Validate() error {
 	if err := validation.ValidateStruct(req,
 		validation.Field(&req.Files, validation.Required),
 		validation.Field(&req.Timeout, validation.Required),
+		validation.Field(&req.Segments, validation.Min(0), validation.Max(16)),
+		validation.Field(&req.MinSegmentSize, validation.Min(int64(0))),
+		validation.Field(&req.Retry),
+		validation.Field(&req.Redirect),
+		validation.Field(&req.requestSettingsReq),
+		validation.Field(&req.MaxBandwidth, validation.Min(int64(0))),
+		validation.Field(&req.MaxFileSize, validation.Min(int64(0))),
+		validation.Field(&req.MaxJobBytes, validation.Min(int64(0))),
+		validation.Field(&req.AllowedMimeTypes, validation.Each(isMimePattern)),
+		validation.Field(&req.DeniedMimeTypes, validation.Each(isMimePattern)),
+		validation.Field(&req.Proxy, isProxyURL),
+		validation.Field(&req.CallbackURL, isHTTPURL),
+		validation.Field(&req.CallbackSecret, validation.By(func(interface{}) error {
+			if req.CallbackSecret != "" && req.CallbackURL == "" {
+				return errors.New("requires callback_url")
+			}
+			return nil
+		})),
 	); err != nil {
 		var ve validation.Errors
 		if errors.As(err, &ve) {
@@ -62,14 +364,35 @@ func (h *HTTPHandlers) CreateDownloadJob(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	urls := make([]string, len(req.Files))
+	sources := make([]entity.DownloadSource, len(req.Files))
 	for i, f := range req.Files {
-		urls[i] = f.URL
+		sources[i] = f.toEntity()
 	}
 
 	rCtx := r.Context()
 
-	createdJob, err := h.DownloadUseCase.StartJob(rCtx, duration, urls)
+	options := entity.DownloadOptions{
+		Segments:         req.Segments,
+		MinSegmentSize:   req.MinSegmentSize,
+		RetryPolicy:      req.Retry.toEntity(),
+		RedirectPolicy:   req.Redirect.toEntity(),
+		Request:          req.requestSettingsReq.toEntity(),
+		Proxy:            entity.Secret(req.Proxy),
+		MaxBandwidth:     req.MaxBandwidth,
+		BypassCache:      req.BypassCache,
+		MaxFileSize:      req.MaxFileSize,
+		MaxJobBytes:      req.MaxJobBytes,
+		AllowedMimeTypes: req.AllowedMimeTypes,
+		DeniedMimeTypes:  req.DeniedMimeTypes,
+	}
+	if req.CallbackURL != "" {
+		options.Callback = &entity.Callback{
+			URL:    req.CallbackURL,
+			Secret: entity.Secret(req.CallbackSecret),
+		}
+	}
+
+	createdJob, err := h.DownloadUseCase.StartJob(rCtx, duration, sources, options)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -90,16 +413,105 @@ type fileErrorDTO struct {
 	Code string `json:"code"`
 }
 
+type attemptDTO struct {
+	StartedAt  time.Time `json:"started_at"`
+	ErrorCode  string    `json:"error_code,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+}
+
+type redirectDTO struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+}
+
+type childDTO struct {
+	Path   string `json:"path"`
+	FileID string `json:"file_id"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
 type fileDTO struct {
-	URL    string        `json:"url"`
-	FileID string        `json:"file_id,omitempty"`
-	Error  *fileErrorDTO `json:"error,omitempty"`
+	URL           string        `json:"url"`
+	State         string        `json:"state"`
+	FileID        string        `json:"file_id,omitempty"`
+	SHA256        string        `json:"sha256,omitempty"`
+	Error         *fileErrorDTO `json:"error,omitempty"`
+	Attempts      []attemptDTO  `json:"attempts,omitempty"`
+	BytesReceived int64         `json:"bytes_received"`
+	BytesTotal    int64         `json:"bytes_total"`
+	Speed         int64         `json:"speed"`
+	CacheHit      bool          `json:"cache_hit"`
+	Revalidated   bool          `json:"revalidated"`
+	Redirects     []redirectDTO `json:"redirects,omitempty"`
+	FinalURL      string        `json:"final_url,omitempty"`
+	Proxy         string        `json:"proxy,omitempty"`
+	Children      []childDTO    `json:"children,omitempty"`
+}
+
+func newFileDTO(item entity.DownloadItem) fileDTO {
+	var errDTO *fileErrorDTO
+	if item.Error != nil {
+		errDTO = &fileErrorDTO{
+			Code: string(item.Error.Code),
+		}
+	}
+	attempts := make([]attemptDTO, len(item.Attempts))
+	for j, attempt := range item.Attempts {
+		attempts[j] = attemptDTO{
+			StartedAt:  attempt.StartedAt,
+			ErrorCode:  string(attempt.ErrorCode),
+			StatusCode: attempt.StatusCode,
+		}
+	}
+	redirects := make([]redirectDTO, len(item.Redirects))
+	for j, redirect := range item.Redirects {
+		redirects[j] = redirectDTO{
+			URL:        redirect.URL,
+			StatusCode: redirect.StatusCode,
+		}
+	}
+	children := make([]childDTO, len(item.Children))
+	for j, child := range item.Children {
+		children[j] = childDTO{
+			Path:   child.Path,
+			FileID: child.FileID,
+			SHA256: child.SHA256,
+			Size:   child.Size,
+		}
+	}
+	return fileDTO{
+		URL:           usecases.RedactURL(item.URL),
+		State:         item.State.String(),
+		FileID:        item.FileID,
+		SHA256:        item.SHA256,
+		Error:         errDTO,
+		Attempts:      attempts,
+		BytesReceived: item.BytesReceived,
+		BytesTotal:    item.BytesTotal,
+		Speed:         item.Speed,
+		CacheHit:      item.CacheHit,
+		Revalidated:   item.Revalidated,
+		Redirects:     redirects,
+		FinalURL:      usecases.RedactURL(item.FinalURL),
+		Proxy:         item.Proxy,
+		Children:      children,
+	}
+}
+
+type deliveryDTO struct {
+	At         time.Time `json:"at"`
+	JobStatus  string    `json:"job_status"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
 }
 
 type jobDTO struct {
-	ID     string    `json:"id"`
-	Status string    `json:"status"`
-	Files  []fileDTO `json:"files"`
+	ID         string        `json:"id"`
+	Status     string        `json:"status"`
+	Throughput int64         `json:"throughput"`
+	Files      []fileDTO     `json:"files"`
+	Deliveries []deliveryDTO `json:"deliveries,omitempty"`
 }
 
 func (h *HTTPHandlers) GetDownloadJob(w http.ResponseWriter, r *http.Request) {
@@ -115,29 +527,181 @@ func (h *HTTPHandlers) GetDownloadJob(w http.ResponseWriter, r *http.Request) {
 	}
 
 	respDTO := jobDTO{
-		ID:     job.ID,
-		Status: job.Status.String(),
-		Files:  make([]fileDTO, len(job.Items)),
+		ID:         job.ID,
+		Status:     job.Status.String(),
+		Throughput: job.Throughput,
+		Files:      make([]fileDTO, len(job.Items)),
 	}
 	for i, item := range job.Items {
-		var errDTO *fileErrorDTO
-		if item.Error != nil {
-			errDTO = &fileErrorDTO{
-				Code: string(item.Error.Code),
-			}
+		respDTO.Files[i] = newFileDTO(item)
+	}
+	for _, d := range job.Deliveries {
+		respDTO.Deliveries = append(respDTO.Deliveries, deliveryDTO{
+			At:         d.At,
+			JobStatus:  d.JobStatus.String(),
+			StatusCode: d.StatusCode,
+			Error:      d.Error,
+		})
+	}
+
+	if err := json.NewEncoder(w).Encode(respDTO); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+}
+
+type jobEventDTO struct {
+	Type   string   `json:"type"`
+	At     string   `json:"at"`
+	Status string   `json:"status,omitempty"`
+	Index  *int     `json:"index,omitempty"`
+	File   *fileDTO `json:"file,omitempty"`
+}
+
+func newJobEventDTO(event entity.JobEvent) jobEventDTO {
+	dto := jobEventDTO{
+		Type: string(event.Type),
+		At:   event.At.Format(time.RFC3339Nano),
+	}
+	if event.Type == entity.EventJobStatus {
+		dto.Status = event.Status.String()
+		return dto
+	}
+
+	file := newFileDTO(event.Item)
+	dto.Index = &event.Index
+	dto.File = &file
+	return dto
+}
+
+// GetDownloadJobEvents streams the progress of a job as Server-Sent Events
+// until the job ends. Clients resume after a reconnect with Last-Event-ID.
+func (h *HTTPHandlers) GetDownloadJobEvents(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobID")
+
+	rCtx := r.Context()
+
+	var lastEventID int64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastEventID = id
+	}
+
+	events, err := h.DownloadUseCase.JobEvents(rCtx, jobID, lastEventID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for event := range events {
+		data, err := json.Marshal(newJobEventDTO(event))
+		if err != nil {
+			slog.Warn("encoding job event failed", "job_id", jobID, "error", err)
+			return
+		}
+
+		if event.ID > 0 {
+			_, err = fmt.Fprintf(w, "id: %d\n", event.ID)
+		}
+		if err == nil {
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
 		}
-		respDTO.Files[i] = fileDTO{
-			URL:    item.URL,
-			FileID: item.FileID,
-			Error:  errDTO,
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			slog.Warn("streaming job events failed", "job_id", jobID, "error", err)
+			return
 		}
 	}
+}
 
-	if err := json.NewEncoder(w).Encode(respDTO); err != nil {
+func (h *HTTPHandlers) ResumeDownloadJob(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobID")
+
+	rCtx := r.Context()
+
+	job, err := h.DownloadUseCase.ResumeJob(rCtx, jobID)
+	if err != nil {
+		if errors.Is(err, usecases.ErrJobRunning) || errors.Is(err, usecases.ErrJobCanceled) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	resDTO := createDownloadJobResp{
+		ID:     job.ID,
+		Status: job.Status.String(),
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(resDTO); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *HTTPHandlers) CancelDownloadJob(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobID")
+
+	rCtx := r.Context()
+
+	job, err := h.DownloadUseCase.CancelJob(rCtx, jobID)
+	if err != nil {
+		if errors.Is(err, usecases.ErrJobNotRunning) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resDTO := createDownloadJobResp{
+		ID:     job.ID,
+		Status: job.Status.String(),
+	}
+
+	if err := json.NewEncoder(w).Encode(resDTO); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *HTTPHandlers) PauseDownloadJob(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobID")
+
+	rCtx := r.Context()
+
+	job, err := h.DownloadUseCase.PauseJob(rCtx, jobID)
+	if err != nil {
+		if errors.Is(err, usecases.ErrJobNotRunning) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resDTO := createDownloadJobResp{
+		ID:     job.ID,
+		Status: job.Status.String(),
+	}
+
+	if err := json.NewEncoder(w).Encode(resDTO); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
 }
 
 func (h *HTTPHandlers) GetFile(w http.ResponseWriter, r *http.Request) {
@@ -146,21 +710,134 @@ func (h *HTTPHandlers) GetFile(w http.ResponseWriter, r *http.Request) {
 
 	rCtx := r.Context()
 
-	file, err := h.DownloadUseCase.GetFile(rCtx, jobID, fileID)
+	content, metadata, err := h.DownloadUseCase.GetFile(rCtx, jobID, fileID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer content.Close()
+
+	// files are downloaded unless asked for inline, and never sniffed again
+	// by the browser, since their content comes from anywhere. A file shown
+	// inline is sandboxed, so active content such as html or svg cannot run
+	// scripts on the origin of the service.
+	disposition := "attachment"
+	if query := r.URL.Query(); query.Has("inline") && !query.Has("attachment") {
+		disposition = "inline"
+	}
+	filename := metadata.Filename
+	if filename == "" {
+		filename = metadata.ID
+	}
+	mimeType := metadata.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	if sum, err := hex.DecodeString(metadata.SHA256); err == nil && len(sum) == sha256.Size {
+		w.Header().Set("ETag", `"`+metadata.SHA256+`"`)
+		w.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum))
+	}
+
+	// ServeContent answers ranges, conditional requests and HEAD from the
+	// ETag above and the time the download finished
+	http.ServeContent(w, r, filename, metadata.FinishedAt, content)
+}
+
+var archiveContentTypes = map[usecases.ArchiveFormat]string{
+	usecases.ArchiveZip:   "application/zip",
+	usecases.ArchiveTarGz: "application/gzip",
+}
+
+func (h *HTTPHandlers) GetDownloadJobArchive(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobID")
+
+	format := usecases.ArchiveFormat(r.URL.Query().Get("format"))
+	if format == "" {
+		format = usecases.ArchiveZip
+	}
+	contentType, ok := archiveContentTypes[format]
+	if !ok {
+		http.Error(w, "format: must be zip or tar.gz", http.StatusBadRequest)
+		return
+	}
+
+	rCtx := r.Context()
 
-	w.Header().Set("Content-Type", file.Metadata.MimeType)
-	if file.Metadata.Size > 0 {
-		w.Header().Set("Content-Length", strconv.FormatInt(file.Metadata.Size, 10))
+	job, err := h.DownloadUseCase.GetJob(rCtx, jobID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": job.ID + "." + string(format),
+	}))
 	w.WriteHeader(http.StatusOK)
 
-	reader := bytes.NewReader(file.Data)
+	// the archive is streamed, so a failure can only cut it short
+	if err := h.DownloadUseCase.WriteArchive(rCtx, job, format, w); err != nil {
+		slog.Warn("streaming archive failed", "job_id", jobID, "error", err)
+	}
+}
 
-	if _, err := io.Copy(w, reader); err != nil {
+type fileMetadataDTO struct {
+	ID               string            `json:"id"`
+	Filename         string            `json:"filename"`
+	MimeType         string            `json:"mime_type"`
+	DeclaredMimeType string            `json:"declared_mime_type,omitempty"`
+	DetectedMimeType string            `json:"detected_mime_type,omitempty"`
+	Size             int64             `json:"size"`
+	SHA256           string            `json:"sha256"`
+	SourceURL        string            `json:"source_url,omitempty"`
+	FinalURL         string            `json:"final_url,omitempty"`
+	ETag             string            `json:"etag,omitempty"`
+	LastModified     string            `json:"last_modified,omitempty"`
+	Headers          map[string]string `json:"headers,omitempty"`
+	StartedAt        *time.Time        `json:"started_at,omitempty"`
+	FinishedAt       *time.Time        `json:"finished_at,omitempty"`
+}
+
+func (h *HTTPHandlers) GetFileMetadata(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobID")
+	fileID := chi.URLParam(r, "fileID")
+
+	rCtx := r.Context()
+
+	metadata, err := h.DownloadUseCase.GetFileMetadata(rCtx, jobID, fileID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respDTO := fileMetadataDTO{
+		ID:               metadata.ID,
+		Filename:         metadata.Filename,
+		MimeType:         metadata.MimeType,
+		DeclaredMimeType: metadata.DeclaredMimeType,
+		DetectedMimeType: metadata.DetectedMimeType,
+		Size:             metadata.Size,
+		SHA256:           metadata.SHA256,
+		SourceURL:        metadata.SourceURL,
+		FinalURL:         metadata.FinalURL,
+		ETag:             metadata.ETag,
+		LastModified:     metadata.LastModified,
+		Headers:          metadata.Headers,
+	}
+	if !metadata.StartedAt.IsZero() {
+		respDTO.StartedAt = &metadata.StartedAt
+	}
+	if !metadata.FinishedAt.IsZero() {
+		respDTO.FinishedAt = &metadata.FinishedAt
+	}
+
+	if err := json.NewEncoder(w).Encode(respDTO); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 }
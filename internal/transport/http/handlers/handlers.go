@@ -6,8 +6,8 @@ type HTTPHandlers struct {
 	DownloadUseCase *usecases.DownloadUseCase
 }
 
-func NewHTTPHandlers() *HTTPHandlers {
+func NewHTTPHandlers(downloadUseCase *usecases.DownloadUseCase) *HTTPHandlers {
 	return &HTTPHandlers{
-		DownloadUseCase: usecases.NewDownloadUseCase(),
+		DownloadUseCase: downloadUseCase,
 	}
 }
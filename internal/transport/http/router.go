@@ -16,7 +16,15 @@ func NewRouter(
 	r.Route("/downloads", func(r chi.Router) {
 		r.Post("/", httpHandlers.CreateDownloadJob)
 		r.Get("/{jobID}", httpHandlers.GetDownloadJob)
+		r.Get("/{jobID}/events", httpHandlers.GetDownloadJobEvents)
+		r.Delete("/{jobID}", httpHandlers.CancelDownloadJob)
+		r.Post("/{jobID}/cancel", httpHandlers.CancelDownloadJob)
+		r.Post("/{jobID}/pause", httpHandlers.PauseDownloadJob)
+		r.Post("/{jobID}/resume", httpHandlers.ResumeDownloadJob)
 		r.Get("/{jobID}/files/{fileID}", httpHandlers.GetFile)
+		r.Head("/{jobID}/files/{fileID}", httpHandlers.GetFile)
+		r.Get("/{jobID}/files/{fileID}/metadata", httpHandlers.GetFileMetadata)
+		r.Get("/{jobID}/archive", httpHandlers.GetDownloadJobArchive)
 	})
 
 	return r
@@ -0,0 +1,172 @@
+package router_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"gin-quickstart/internal/domain/entity"
+	router "gin-quickstart/internal/transport/http"
+	"gin-quickstart/internal/transport/http/handlers"
+	"gin-quickstart/internal/usecases"
+	"gin-quickstart/pkg/netguard"
+)
+
+// storeFile downloads body served as contentType and returns the router
+// serving it with the path of the stored file.
+func storeFile(t *testing.T, body, contentType string) (http.Handler, string) {
+	t.Helper()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		_, _ = io.WriteString(w, body)
+	}))
+	defer upstream.Close()
+
+	// loopback lets the use case reach the httptest server, blocked by default
+	u := usecases.NewDownloadUseCase(usecases.WithNetGuard(netguard.New(
+		netguard.WithAllowCIDRs(netip.MustParsePrefix("127.0.0.0/8")),
+	)))
+	created, err := u.StartJob(context.Background(), 5*time.Second, []entity.DownloadSource{{URL: upstream.URL + "/file"}}, entity.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		job, err := u.GetJob(context.Background(), created.ID)
+		if err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		if job.Status == entity.Process {
+			continue
+		}
+		if item := job.Items[0]; item.State != entity.ItemDone {
+			t.Fatalf("expected item done, got %+v", item)
+		}
+		return router.NewRouter(handlers.NewHTTPHandlers(u)), "/downloads/" + job.ID + "/files/" + job.Items[0].FileID
+	}
+	t.Fatalf("timeout waiting for job %s", created.ID)
+	return nil, ""
+}
+
+func TestRouter_GetFile_SandboxesContent(t *testing.T) {
+	r, path := storeFile(t, "<script>alert(document.cookie)</script>", "text/html")
+
+	for _, query := range []string{"", "?inline"} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: expected 200, got %d", query, rec.Code)
+		}
+		if csp := rec.Header().Get("Content-Security-Policy"); csp != "default-src 'none'; sandbox" {
+			t.Fatalf("%q: expected a sandboxing policy, got %q", query, csp)
+		}
+		if nosniff := rec.Header().Get("X-Content-Type-Options"); nosniff != "nosniff" {
+			t.Fatalf("%q: expected nosniff, got %q", query, nosniff)
+		}
+	}
+}
+
+func TestRouter_GetFile_ServesRangesAndConditionals(t *testing.T) {
+	const body = "0123456789abcdefghij"
+	r, path := storeFile(t, body, "text/plain")
+	sum := sha256.Sum256([]byte(body))
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	serve := func(method string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("single range", func(t *testing.T) {
+		rec := serve(http.MethodGet, map[string]string{"Range": "bytes=5-9"})
+		if rec.Code != http.StatusPartialContent {
+			t.Fatalf("expected 206, got %d", rec.Code)
+		}
+		if cr := rec.Header().Get("Content-Range"); cr != "bytes 5-9/"+strconv.Itoa(len(body)) {
+			t.Fatalf("unexpected Content-Range %q", cr)
+		}
+		if got := rec.Body.String(); got != body[5:10] {
+			t.Fatalf("expected %q, got %q", body[5:10], got)
+		}
+	})
+
+	t.Run("multiple ranges", func(t *testing.T) {
+		rec := serve(http.MethodGet, map[string]string{"Range": "bytes=0-1,10-12"})
+		if rec.Code != http.StatusPartialContent {
+			t.Fatalf("expected 206, got %d", rec.Code)
+		}
+		mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("expected multipart/byteranges, got %q", rec.Header().Get("Content-Type"))
+		}
+
+		want := map[string]string{
+			"bytes 0-1/20":   body[0:2],
+			"bytes 10-12/20": body[10:13],
+		}
+		mr := multipart.NewReader(rec.Body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("expected nil err, got %v", err)
+			}
+			data, _ := io.ReadAll(part)
+			cr := part.Header.Get("Content-Range")
+			if want[cr] != string(data) {
+				t.Fatalf("unexpected part %q: %q", cr, data)
+			}
+			delete(want, cr)
+		}
+		if len(want) != 0 {
+			t.Fatalf("missing parts %v", want)
+		}
+	})
+
+	t.Run("if-none-match", func(t *testing.T) {
+		rec := serve(http.MethodGet, map[string]string{"If-None-Match": etag})
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Fatalf("expected an empty 304, got %d with %d bytes", rec.Code, rec.Body.Len())
+		}
+		if got := rec.Header().Get("ETag"); got != etag {
+			t.Fatalf("expected ETag %s, got %s", etag, got)
+		}
+
+		if rec := serve(http.MethodGet, map[string]string{"If-None-Match": `"other"`}); rec.Code != http.StatusOK || rec.Body.String() != body {
+			t.Fatalf("expected the file for another ETag, got %d", rec.Code)
+		}
+	})
+
+	t.Run("head", func(t *testing.T) {
+		rec := serve(http.MethodHead, nil)
+		if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+			t.Fatalf("expected 200 without a body, got %d with %d bytes", rec.Code, rec.Body.Len())
+		}
+		if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(len(body)) {
+			t.Fatalf("expected Content-Length %d, got %q", len(body), got)
+		}
+		if got := rec.Header().Get("ETag"); got != etag {
+			t.Fatalf("expected ETag %s, got %s", etag, got)
+		}
+		if got := rec.Header().Get("Accept-Ranges"); got != "bytes" {
+			t.Fatalf("expected Accept-Ranges bytes, got %q", got)
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package usecases
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gin-quickstart/internal/domain/entity"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+type ArchiveFormat string
+
+const (
+	ArchiveZip   ArchiveFormat = "zip"
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+
+	manifestName = "manifest.json"
+)
+
+var ErrUnknownArchiveFormat = errors.New("unknown archive format")
+
+type manifest struct {
+	JobID     string           `json:"job_id"`
+	Status    string           `json:"status"`
+	CreatedAt time.Time        `json:"created_at"`
+	Files     []manifestFile   `json:"files"`
+	Failed    []manifestFailed `json:"failed"`
+}
+
+type manifestFile struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	FinalURL string `json:"final_url,omitempty"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+}
+
+type manifestFailed struct {
+	URL   string `json:"url"`
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+// archiveWriter adds entries to an archive of one format.
+type archiveWriter interface {
+	add(name string, size int64, modified time.Time, content io.Reader) error
+	Close() error
+}
+
+type zipArchive struct {
+	*zip.Writer
+}
+
+func (a zipArchive) add(name string, _ int64, modified time.Time, content io.Reader) error {
+	w, err := a.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, content)
+	return err
+}
+
+type tarGzArchive struct {
+	tar *tar.Writer
+	gz  *gzip.Writer
+}
+
+func (a tarGzArchive) add(name string, size int64, modified time.Time, content io.Reader) error {
+	if err := a.tar.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  modified,
+	}); err != nil {
+		return err
+	}
+	_, err := io.Copy(a.tar, content)
+	return err
+}
+
+func (a tarGzArchive) Close() error {
+	if err := a.tar.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+func newArchiveWriter(format ArchiveFormat, w io.Writer) (archiveWriter, error) {
+	switch format {
+	case ArchiveZip:
+		return zipArchive{zip.NewWriter(w)}, nil
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		return tarGzArchive{tar: tar.NewWriter(gz), gz: gz}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownArchiveFormat, format)
+}
+
+// archiveName returns the name of the file of item in an archive when the
+// file has no derived filename: the last segment of the URL it came from, or
+// file-<index> without one.
+func archiveName(item entity.DownloadItem, index int) string {
+	source := item.FinalURL
+	if source == "" {
+		source = item.URL
+	}
+
+	name := ""
+	if u, err := url.Parse(source); err == nil {
+		name = path.Base(u.Path)
+	}
+	if name == "" || name == "." || name == "/" || name == ".." {
+		name = fmt.Sprintf("file-%d", index+1)
+	}
+	return strings.ReplaceAll(name, "\\", "_")
+}
+
+// uniqueNames hands out names not taken yet, numbering the repeated ones as
+// in name-1.ext, name-2.ext.
+type uniqueNames map[string]bool
+
+func (taken uniqueNames) claim(name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	unique := name
+	for n := 1; taken[unique]; n++ {
+		unique = fmt.Sprintf("%s-%d%s", base, n, ext)
+	}
+	taken[unique] = true
+	return unique
+}
+
+// WriteArchive streams the downloaded files of job into w one after another,
+// as an archive of format ending with a manifest.json of the job.
+func (u *DownloadUseCase) WriteArchive(ctx context.Context, job entity.DownloadJob, format ArchiveFormat, w io.Writer) error {
+	archive, err := newArchiveWriter(format, w)
+	if err != nil {
+		return err
+	}
+
+	m := manifest{
+		JobID:     job.ID,
+		Status:    job.Status.String(),
+		CreatedAt: job.CreatedAt,
+		Files:     []manifestFile{},
+		Failed:    []manifestFailed{},
+	}
+	names := uniqueNames{manifestName: true}
+
+	for i, item := range job.Items {
+		if item.State != entity.ItemDone {
+			failed := manifestFailed{URL: RedactURL(item.URL), State: item.State.String()}
+			if item.Error != nil {
+				failed.Error = string(item.Error.Code)
+			}
+			m.Failed = append(m.Failed, failed)
+			continue
+		}
+
+		content, metadata, err := u.FileRepository.Open(ctx, item.FileID)
+		if err != nil {
+			return err
+		}
+		name := metadata.Filename
+		if name == "" {
+			name = archiveName(item, i)
+		}
+		name = names.claim(name)
+		err = archive.add(name, metadata.Size, job.UpdatedAt, content)
+		_ = content.Close()
+		if err != nil {
+			return err
+		}
+
+		m.Files = append(m.Files, manifestFile{
+			Name:     name,
+			URL:      RedactURL(item.URL),
+			FinalURL: RedactURL(item.FinalURL),
+			SHA256:   metadata.SHA256,
+			Size:     metadata.Size,
+		})
+	}
+
+	body, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := archive.add(manifestName, int64(len(body)), job.UpdatedAt, strings.NewReader(string(body))); err != nil {
+		return err
+	}
+	return archive.Close()
+}
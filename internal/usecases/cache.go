@@ -0,0 +1,269 @@
+package usecases
+
+import (
+	"container/list"
+	"context"
+	"gin-quickstart/internal/domain/entity"
+	"gin-quickstart/internal/domain/ports"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithHTTPCache shares downloads across jobs: up to maxEntries URLs keep
+// their last file and validators, so a fresh copy is reused without any
+// request and a stale one is revalidated with a conditional request.
+func WithHTTPCache(maxEntries int) Option {
+	return func(u *DownloadUseCase) {
+		if maxEntries > 0 {
+			u.cache = newHTTPCache(maxEntries)
+		}
+	}
+}
+
+// cacheEntry is the last download of a URL. fileID belongs to the cache, so
+// it outlives the files handed out to jobs.
+type cacheEntry struct {
+	url          string
+	fileID       string
+	etag         string
+	lastModified string
+	storedAt     time.Time
+	// maxAge is how long the file is fresh after storedAt; zero means it is
+	// revalidated every time.
+	maxAge time.Duration
+	// redirects and finalURL are where the file was downloaded from.
+	redirects []entity.Redirect
+	finalURL  string
+}
+
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return e.maxAge > 0 && now.Sub(e.storedAt) < e.maxAge
+}
+
+// applyConditional asks the upstream to answer not modified if the file did
+// not change.
+func (e *cacheEntry) applyConditional(req *ports.FetchRequest) {
+	if e == nil {
+		return
+	}
+	req.IfNoneMatch = e.etag
+	req.IfModifiedSince = e.lastModified
+}
+
+// cacheDirectives returns the Cache-Control directives of header, lower cased.
+func cacheDirectives(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// freshness returns how long a response with header stays fresh, from
+// s-maxage or max-age less its Age.
+func freshness(header http.Header, directives map[string]string) time.Duration {
+	if _, noCache := directives["no-cache"]; noCache {
+		return 0
+	}
+
+	arg, ok := directives["s-maxage"]
+	if !ok {
+		arg, ok = directives["max-age"]
+	}
+	if !ok {
+		return 0
+	}
+	maxAge, err := strconv.Atoi(arg)
+	if err != nil || maxAge <= 0 {
+		return 0
+	}
+
+	age, _ := strconv.Atoi(header.Get("Age"))
+	return time.Duration(maxAge-age) * time.Second
+}
+
+// newCacheEntry returns the entry for a response with header, or false if a
+// shared cache must not or cannot reuse it.
+func newCacheEntry(url string, header http.Header) (*cacheEntry, bool) {
+	directives := cacheDirectives(header)
+	if _, noStore := directives["no-store"]; noStore {
+		return nil, false
+	}
+	if _, private := directives["private"]; private {
+		return nil, false
+	}
+
+	e := &cacheEntry{
+		url:          url,
+		etag:         header.Get("ETag"),
+		lastModified: header.Get("Last-Modified"),
+		storedAt:     time.Now(),
+		maxAge:       freshness(header, directives),
+	}
+	return e, e.etag != "" || e.lastModified != "" || e.maxAge > 0
+}
+
+// httpCache indexes cache entries by URL and evicts the least recently used.
+// Storing and freeing the files themselves is left to the caller.
+type httpCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List
+}
+
+func newHTTPCache(maxEntries int) *httpCache {
+	return &httpCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (c *httpCache) get(url string) (cacheEntry, bool) {
+	if c == nil {
+		return cacheEntry{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exists := c.entries[url]
+	if !exists {
+		return cacheEntry{}, false
+	}
+	c.lru.MoveToFront(elem)
+	return *elem.Value.(*cacheEntry), true
+}
+
+// put stores e and returns the files of the entries it replaced or evicted.
+func (c *httpCache) put(e *cacheEntry) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var evicted []string
+	if elem, exists := c.entries[e.url]; exists {
+		evicted = append(evicted, elem.Value.(*cacheEntry).fileID)
+		c.lru.Remove(elem)
+	}
+	c.entries[e.url] = c.lru.PushFront(e)
+
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Remove(c.lru.Back()).(*cacheEntry)
+		delete(c.entries, oldest.url)
+		evicted = append(evicted, oldest.fileID)
+	}
+	return evicted
+}
+
+// refresh restarts the freshness of the entry of url after a 304 with header.
+func (c *httpCache) refresh(url string, header http.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exists := c.entries[url]
+	if !exists {
+		return
+	}
+	e := elem.Value.(*cacheEntry)
+	e.storedAt = time.Now()
+	e.maxAge = freshness(header, cacheDirectives(header))
+	if etag := header.Get("ETag"); etag != "" {
+		e.etag = etag
+	}
+}
+
+// remove drops the entry of url if it still holds fileID and reports whether it did.
+func (c *httpCache) remove(url, fileID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exists := c.entries[url]
+	if !exists || elem.Value.(*cacheEntry).fileID != fileID {
+		return false
+	}
+	c.lru.Remove(elem)
+	delete(c.entries, url)
+	return true
+}
+
+// cacheFile keeps a copy of a file downloaded for t for later jobs when the
+// response header allows it and the request carried no credentials.
+func (u *DownloadUseCase) cacheFile(ctx context.Context, t *itemTask, header http.Header, metadata entity.FileMetadata) {
+	if u.cache == nil || t.private() {
+		return
+	}
+
+	e, ok := newCacheEntry(t.source.URL, header)
+	if !ok {
+		return
+	}
+	e.redirects = t.redirects
+	e.finalURL = t.finalURL
+
+	clone, err := u.FileRepository.Clone(ctx, metadata.ID)
+	if err != nil {
+		slog.Warn("caching file failed", "url", RedactURL(t.source.URL), "error", err)
+		return
+	}
+	e.fileID = clone.ID
+
+	for _, fileID := range u.cache.put(e) {
+		_ = u.FileRepository.Delete(ctx, fileID)
+	}
+}
+
+// reuseCached hands out a new file sharing the content of a cache entry,
+// provided it still exists and matches the expected checksum of t.
+func (u *DownloadUseCase) reuseCached(ctx context.Context, t *itemTask, e cacheEntry) (entity.FileMetadata, error) {
+	metadata, err := u.FileRepository.Clone(ctx, e.fileID)
+	if err != nil {
+		if u.cache.remove(e.url, e.fileID) {
+			_ = u.FileRepository.Delete(ctx, e.fileID)
+		}
+		return entity.FileMetadata{}, err
+	}
+
+	if err := u.verifyFile(ctx, metadata, t.source.Checksum); err != nil {
+		_ = u.FileRepository.Delete(ctx, metadata.ID)
+		return entity.FileMetadata{}, err
+	}
+	return metadata, nil
+}
+
+// verifyFile checks a stored file against the expected checksum, reading it
+// back only when the algorithm is not the sha256 kept in its metadata.
+func (u *DownloadUseCase) verifyFile(ctx context.Context, metadata entity.FileMetadata, expected *entity.Checksum) error {
+	if expected == nil {
+		return nil
+	}
+	if expected.Algorithm == entity.DigestSHA256 {
+		if !strings.EqualFold(metadata.SHA256, expected.Value) {
+			return errChecksumMismatch
+		}
+		return nil
+	}
+
+	content, _, err := u.FileRepository.Open(ctx, metadata.ID)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	digest := newDigester(expected)
+	if _, err := io.Copy(digest, content); err != nil {
+		return err
+	}
+	_, err = digest.verify()
+	return err
+}
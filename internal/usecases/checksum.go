@@ -0,0 +1,59 @@
+package usecases
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"gin-quickstart/internal/domain/entity"
+	"hash"
+	"strings"
+)
+
+var errChecksumMismatch = errors.New("downloaded file does not match the expected checksum")
+
+func newHash(algorithm entity.DigestAlgorithm) hash.Hash {
+	switch algorithm {
+	case entity.DigestSHA512:
+		return sha512.New()
+	case entity.DigestMD5:
+		return md5.New()
+	default:
+		return sha256.New()
+	}
+}
+
+// digester hashes a file as it is written: always with sha256 for the stored
+// metadata, and with the algorithm of the expected checksum if there is one.
+type digester struct {
+	sha256   hash.Hash
+	expected *entity.Checksum
+	hash     hash.Hash
+}
+
+func newDigester(expected *entity.Checksum) *digester {
+	d := &digester{sha256: sha256.New(), expected: expected}
+	d.hash = d.sha256
+	if expected != nil && expected.Algorithm != entity.DigestSHA256 {
+		d.hash = newHash(expected.Algorithm)
+	}
+	return d
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	d.sha256.Write(p)
+	if d.hash != d.sha256 {
+		d.hash.Write(p)
+	}
+	return len(p), nil
+}
+
+// verify returns the sha256 of everything written, or errChecksumMismatch when
+// it does not match the expected checksum.
+func (d *digester) verify() (string, error) {
+	if d.expected != nil && !strings.EqualFold(hex.EncodeToString(d.hash.Sum(nil)), d.expected.Value) {
+		return "", errChecksumMismatch
+	}
+	return hex.EncodeToString(d.sha256.Sum(nil)), nil
+}
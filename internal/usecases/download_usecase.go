@@ -1,78 +1,290 @@
 package usecases
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"gin-quickstart/internal/domain/entity"
 	"gin-quickstart/internal/domain/ports"
+	"gin-quickstart/internal/infra/fetcher"
 	repository "gin-quickstart/internal/infra/repository/memory"
+	"gin-quickstart/pkg/bandwidth"
+	"gin-quickstart/pkg/egress"
+	"gin-quickstart/pkg/hostlimiter"
+	"gin-quickstart/pkg/netguard"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
-	"sync"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 )
 
 const (
-	fileMaxSize = int64(10 << 20) // 10mb
+	fileMaxSize    = int64(10 << 20) // 10mb
+	jobConcurrency = 10
+
+	// progressInterval is how often a running job stores the progress of its items.
+	progressInterval = 500 * time.Millisecond
+
+	defaultHostMaxConns   = 8
+	defaultGlobalMaxConns = 64
+
+	// A transfer as a whole is only bounded by the job, these catch an upstream
+	// that stops answering part way.
+	dialTimeout           = 30 * time.Second
+	responseHeaderTimeout = 30 * time.Second
+	defaultStallTimeout   = 30 * time.Second
+)
+
+var (
+	ErrJobRunning    = errors.New("job is still running")
+	ErrJobNotRunning = errors.New("job is not running")
+	// ErrJobCanceled refuses to resume a job whose end was already reported.
+	ErrJobCanceled = errors.New("job is canceled")
+
+	errJobCanceled = errors.New("job canceled")
+	errJobPaused   = errors.New("job paused")
+
+	errUnsupportedScheme = errors.New("unsupported URL scheme")
+	errStalled           = errors.New("upstream sent no data in time")
 )
 
 type DownloadUseCase struct {
 	DownloadJobRepository ports.DownloadJobRepository
 	FileRepository        ports.FileRepository
 	httpClient            *http.Client
+	guard                 *netguard.Guard
+	proxies               *egress.Router
+	fetchers              map[string]ports.Fetcher
+	partials              *partialStore
+	retryPolicy           entity.RetryPolicy
+	running               *jobRegistry
+	hosts                 *hostlimiter.Limiter
+	bandwidth             *bandwidth.Limiter
+	events                *eventBroker
+	webhookClient         *http.Client
+	webhookPolicy         entity.RetryPolicy
+	webhooks              *webhookLog
+	cache                 *httpCache
+	maxFileSize           int64
+	maxJobBytes           int64
+	stallTimeout          time.Duration
+	extractMaxEntries     int
+	extractMaxBytes       int64
+	userAgent             string
+	provenanceHeaders     []string
+}
+
+type Option func(*DownloadUseCase)
+
+// WithRetryPolicy sets the service-wide retry defaults that jobs may override.
+func WithRetryPolicy(policy entity.RetryPolicy) Option {
+	return func(u *DownloadUseCase) {
+		u.retryPolicy = policy
+	}
+}
+
+// WithHostLimiter replaces the scheduler that caps outbound connections and
+// request rates per host across all jobs.
+func WithHostLimiter(limiter *hostlimiter.Limiter) Option {
+	return func(u *DownloadUseCase) {
+		u.hosts = limiter
+	}
+}
+
+// WithBandwidthLimit caps the bytes per second all jobs together may download.
+// Running jobs split it evenly unless their own max_bandwidth is lower.
+func WithBandwidthLimit(bytesPerSec int64) Option {
+	return func(u *DownloadUseCase) {
+		u.bandwidth = bandwidth.NewLimiter(bytesPerSec)
+	}
+}
+
+// WithFetcher serves the URLs of scheme with f instead of the built-in
+// fetcher, if there is one.
+func WithFetcher(scheme string, f ports.Fetcher) Option {
+	return func(u *DownloadUseCase) {
+		u.fetchers[strings.ToLower(scheme)] = f
+	}
+}
+
+// WithStallTimeout fails a transfer whose upstream sends nothing for d while
+// the download waits on it. Zero never gives up on an upstream.
+func WithStallTimeout(d time.Duration) Option {
+	return func(u *DownloadUseCase) {
+		u.stallTimeout = d
+	}
+}
+
+// WithNetGuard replaces the guard that keeps downloads and webhooks away from
+// internal destinations.
+func WithNetGuard(guard *netguard.Guard) Option {
+	return func(u *DownloadUseCase) {
+		u.guard = guard
+	}
 }
 
-func NewDownloadUseCase() *DownloadUseCase {
-	return &DownloadUseCase{
+func NewDownloadUseCase(options ...Option) *DownloadUseCase {
+	u := &DownloadUseCase{
 		DownloadJobRepository: repository.NewDownloadJobMemoryRepository(),
 		FileRepository:        repository.NewFileMemoryRepository(),
-		httpClient: &http.Client{
-			Timeout: 5 * time.Second,
-		},
+		guard:                 netguard.New(),
+		fetchers:              make(map[string]ports.Fetcher),
+		partials:              newPartialStore(),
+		retryPolicy:           DefaultRetryPolicy,
+		running:               newJobRegistry(),
+		hosts: hostlimiter.New(
+			hostlimiter.WithGlobalMaxConns(defaultGlobalMaxConns),
+			hostlimiter.WithDefaultLimit(hostlimiter.Limit{MaxConns: defaultHostMaxConns}),
+		),
+		bandwidth:         bandwidth.NewLimiter(0),
+		events:            newEventBroker(),
+		webhookPolicy:     DefaultWebhookRetryPolicy,
+		webhooks:          newWebhookLog(),
+		maxFileSize:       fileMaxSize,
+		stallTimeout:      defaultStallTimeout,
+		extractMaxEntries: defaultExtractMaxEntries,
+		extractMaxBytes:   defaultExtractMaxBytes,
+		userAgent:         defaultUserAgent,
+		provenanceHeaders: DefaultProvenanceHeaders,
+	}
+
+	for _, opt := range options {
+		opt(u)
 	}
+
+	transport := newProxyTransport(u.guard, u.proxies)
+	u.httpClient = &http.Client{
+		Transport:     transport,
+		CheckRedirect: u.checkRedirect,
+	}
+	u.webhookClient = &http.Client{
+		Transport:     u.guard.Transport(),
+		CheckRedirect: u.guard.CheckRedirect,
+	}
+
+	httpFetcher := fetcher.NewHTTPFetcher(u.httpClient, u.userAgent)
+	ftpFetcher := fetcher.NewFTPFetcher(fetcher.WithFTPDialer(transport.dialFTP))
+	for scheme, f := range map[string]ports.Fetcher{
+		"http":  httpFetcher,
+		"https": httpFetcher,
+		"ftp":   ftpFetcher,
+		"ftps":  ftpFetcher,
+	} {
+		if _, exists := u.fetchers[scheme]; !exists {
+			u.fetchers[scheme] = f
+		}
+	}
+
+	return u
 }
 
-func (u *DownloadUseCase) fetchFile(ctx context.Context, url string) (*http.Response, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// fetch requests the file of req from the fetcher of its scheme, with the job
+// settings of trace, and records on it how the request went.
+func (u *DownloadUseCase) fetch(ctx context.Context, req ports.FetchRequest, trace *requestTrace) (*ports.FetchResponse, error) {
+	parsed, err := url.Parse(req.URL)
 	if err != nil {
 		return nil, err
 	}
+	f, ok := u.fetchers[strings.ToLower(parsed.Scheme)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", errUnsupportedScheme, parsed.Scheme)
+	}
 
-	req.Header.Set("User-Agent", "go-school-downloader/1.0 (contact: dim.i@gmail.com)")
+	release, err := u.hosts.Acquire(ctx, parsed.Hostname())
+	if err != nil {
+		return nil, err
+	}
 
-	client := u.httpClient
-	resp, err := client.Do(req)
+	resp, err := f.Fetch(context.WithValue(ctx, requestTraceKey{}, trace), req)
 	if err != nil {
+		release()
 		return nil, err
 	}
 
+	if u.stallTimeout > 0 {
+		resp.Body = &stallingBody{ReadCloser: resp.Body, timeout: u.stallTimeout}
+	}
+	// the host slot is held for as long as the body is being read
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+
 	return resp, nil
 }
 
-type upstreamError struct {
-	Status     string
-	StatusCode int
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
+
+// stallingBody closes a body whose reads wait longer than timeout. Only the
+// time spent in Read counts, a download held back by its bandwidth limit is
+// not stalled.
+type stallingBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	stalled atomic.Bool
 }
 
-func (e *upstreamError) Error() string {
-	return fmt.Sprintf("Upstream error: %s %d", e.Status, e.StatusCode)
+func (b *stallingBody) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(b.timeout, func() {
+		b.stalled.Store(true)
+		_ = b.ReadCloser.Close()
+	})
+	n, err := b.ReadCloser.Read(p)
+	if !timer.Stop() && b.stalled.Load() {
+		return n, errStalled
+	}
+	return n, err
 }
 
 func getErrorCode(err error) entity.DownloadItemErrorCode {
 	var netErr net.Error
-	var upstreamErr *upstreamError
-	if errors.Is(err, context.DeadlineExceeded) {
+	var upstreamErr *ports.UpstreamError
+	if errors.Is(err, netguard.ErrBlocked) {
+		return entity.ErrorBlockedDestination
+	} else if errors.Is(err, errRedirectNotAllowed) {
+		return entity.ErrorRedirectNotAllowed
+	} else if errors.Is(err, ports.ErrInvalidURL) {
+		return entity.ErrorInvalidURL
+	} else if errors.Is(err, errProxyUnsupported) {
+		return entity.ErrorProxyUnsupported
+	} else if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errStalled) {
 		return entity.ErrorTimeout
 	} else if errors.As(err, &netErr) {
-		return entity.ErrorHTTP
+		if netErr.Timeout() {
+			return entity.ErrorTimeout
+		}
+		return entity.ErrorNetwork
 	} else if errors.As(err, &upstreamErr) {
 		return entity.ErrorHTTP
+	} else if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errRangeMismatch) || errors.Is(err, ports.ErrTemporary) {
+		return entity.ErrorNetwork
+	} else if errors.Is(err, errChecksumMismatch) {
+		return entity.ErrorChecksumMismatch
+	} else if errors.Is(err, errTooLarge) {
+		return entity.ErrorTooLarge
+	} else if errors.Is(err, errJobQuotaExceeded) {
+		return entity.ErrorJobQuotaExceeded
+	} else if errors.Is(err, errMimeNotAllowed) {
+		return entity.ErrorMimeNotAllowed
+	} else if errors.Is(err, errUnsafeArchivePath) {
+		return entity.ErrorUnsafeArchive
+	} else if errors.Is(err, errArchiveTooLarge) {
+		return entity.ErrorArchiveTooLarge
+	} else if errors.Is(err, errNotArchive) {
+		return entity.ErrorExtractFailed
 	}
 	return entity.ErrorUnknown
 }
@@ -84,152 +296,707 @@ func isFatalErr(err error) bool {
 	return false
 }
 
-type jobCollector struct {
-	mu  sync.Mutex
-	job *entity.DownloadJob
+// itemTask is one item of a job run, identified by its index in the job.
+type itemTask struct {
+	run      *jobRun
+	index    int
+	source   entity.DownloadSource
+	progress *itemProgress
+	// cached is the stale cache entry of the source to revalidate, if any.
+	cached *cacheEntry
+	// redirects, finalURL and proxy describe the last request for the source.
+	redirects []entity.Redirect
+	finalURL  string
+	proxy     string
 }
 
-func NewJobCollector(job *entity.DownloadJob) *jobCollector {
-	return &jobCollector{
-		job: job,
+// newTrace returns the trace for a request of t.
+func (t *itemTask) newTrace() *requestTrace {
+	trace := &requestTrace{redirectPolicy: t.run.redirectPolicy, proxy: t.run.proxy}
+	for name := range t.requestHeader() {
+		trace.headers = append(trace.headers, name)
 	}
+	return trace
 }
 
-func (jc *jobCollector) addItemError(url string, err error) {
-	jc.mu.Lock()
-	defer jc.mu.Unlock()
-	jc.job.Items = append(jc.job.Items, entity.DownloadItem{
-		URL:   url,
-		Error: &entity.DownloadItemError{Code: getErrorCode(err)},
-	})
+// sourceURL is where the file of t came from, the requested URL until a
+// response said otherwise.
+func (t *itemTask) sourceURL() string {
+	if t.finalURL != "" {
+		return t.finalURL
+	}
+	return t.source.URL
 }
 
-func (jc *jobCollector) addItemSuccess(url, fileID string) {
-	jc.mu.Lock()
-	defer jc.mu.Unlock()
-	jc.job.Items = append(jc.job.Items, entity.DownloadItem{
-		URL:    url,
-		FileID: fileID,
+// record keeps the redirects and proxy of the last request for the source
+// and, once it got a response, the URL the response came from.
+func (t *itemTask) record(trace *requestTrace, resp *ports.FetchResponse) {
+	t.redirects = trace.hops
+	t.proxy = trace.via
+	t.finalURL = ""
+	if resp != nil {
+		t.finalURL = resp.URL
+	}
+}
+
+// downloadItem fetches the source of t according to the retry policy of the job
+// and records every attempt on the returned item. Interrupted transfers are
+// resumed from their checkpoint on the next attempt and are kept once the
+// attempts run out, for as long as the job may still be resumed.
+func (u *DownloadUseCase) downloadItem(ctx context.Context, t *itemTask) (entity.DownloadItem, error) {
+	run := t.run
+	item := entity.DownloadItem{DownloadSource: t.source, State: entity.ItemFailed, BytesTotal: -1}
+
+	key := partialKey{jobID: run.jobID, index: t.index}
+	partial := u.partials.take(key)
+
+	// a cached file is a miss for a job whose policy refuses how it was fetched
+	if cached, ok := u.cache.get(t.source.URL); ok && partial == nil && !run.options.BypassCache && !t.private() &&
+		checkRedirectChain(run.redirectPolicy, t.source.URL, cached.redirects) == nil {
+		if !cached.fresh(time.Now()) {
+			t.cached = &cached
+		} else if metadata, err := u.reuseCached(ctx, t, cached); err == nil {
+			if err := run.limits.admit(metadata); err != nil {
+				_ = u.FileRepository.Delete(ctx, metadata.ID)
+				item.Error = &entity.DownloadItemError{Code: getErrorCode(err)}
+				return item, err
+			}
+			item.State = entity.ItemDone
+			item.FileID = metadata.ID
+			item.SHA256 = metadata.SHA256
+			item.BytesReceived = metadata.Size
+			item.BytesTotal = metadata.Size
+			item.CacheHit = true
+			item.Redirects = cached.redirects
+			item.FinalURL = cached.finalURL
+			return item, nil
+		}
+	}
+
+	for {
+		attempt := entity.DownloadAttempt{StartedAt: time.Now()}
+
+		metadata, next, err := u.downloadFile(ctx, t, partial, &attempt)
+		item.Redirects = t.redirects
+		item.Proxy = t.proxy
+		if err == nil {
+			item.Attempts = append(item.Attempts, attempt)
+			item.State = entity.ItemDone
+			item.FileID = metadata.ID
+			item.SHA256 = metadata.SHA256
+			item.BytesReceived = metadata.Size
+			item.BytesTotal = metadata.Size
+			item.Revalidated = attempt.StatusCode == http.StatusNotModified
+			item.CacheHit = item.Revalidated
+			item.FinalURL = t.finalURL
+			return item, nil
+		}
+
+		attempt.ErrorCode = getErrorCode(err)
+		item.Attempts = append(item.Attempts, attempt)
+
+		if ctx.Err() == nil && len(item.Attempts) < run.retryPolicy.MaxAttempts && isRetryable(run.retryPolicy, err) {
+			delay := backoff(run.retryPolicy, len(item.Attempts), err)
+			slog.Info("retrying download", "url", RedactURL(t.source.URL), "attempt", len(item.Attempts), "delay", delay, "error", err)
+
+			if err = run.sleep(ctx, delay); err == nil {
+				partial = next
+				continue
+			}
+		}
+
+		// only the bytes of a checkpoint survive the failure
+		if next != nil {
+			u.partials.put(key, next)
+			item.BytesReceived = next.offset
+		}
+		item.BytesTotal = t.progress.total.Load()
+		item.Error = &entity.DownloadItemError{Code: getErrorCode(err)}
+		return item, err
+	}
+}
+
+// downloadFile makes one attempt at url, as parallel segments when the job
+// asks for it and the upstream allows it, otherwise as a single transfer. A
+// stale cached file is always revalidated with a single request.
+func (u *DownloadUseCase) downloadFile(ctx context.Context, t *itemTask, partial *partialDownload, attempt *entity.DownloadAttempt) (entity.FileMetadata, *partialDownload, error) {
+	if partial == nil && t.cached == nil {
+		metadata, handled, err := u.segmentedTransfer(ctx, t, attempt)
+		if handled {
+			return metadata, nil, err
+		}
+	}
+	return u.transfer(ctx, t, partial, attempt)
+}
+
+// transfer performs one request for the source of t, continuing partial when it
+// is set. On failure it returns the checkpoint worth resuming from, if any. A
+// resumable transfer is interrupted when the job is paused; the others finish.
+func (u *DownloadUseCase) transfer(ctx context.Context, t *itemTask, partial *partialDownload, attempt *entity.DownloadAttempt) (entity.FileMetadata, *partialDownload, error) {
+	run := t.run
+
+	req := ports.FetchRequest{URL: t.source.URL, Header: t.requestHeader()}
+	partial.applyRange(&req)
+	if partial == nil {
+		t.cached.applyConditional(&req)
+	}
+
+	reqCtx, cancelReq := context.WithCancelCause(ctx)
+	defer cancelReq(nil)
+
+	trace := t.newTrace()
+	resp, err := u.fetch(reqCtx, req, trace)
+	t.record(trace, resp)
+	if err != nil {
+		var upstreamErr *ports.UpstreamError
+		if errors.As(err, &upstreamErr) {
+			attempt.StatusCode = upstreamErr.StatusCode
+		}
+		return entity.FileMetadata{}, partial, err
+	}
+	defer resp.Body.Close()
+
+	attempt.StatusCode = resp.StatusCode
+
+	var size int64
+	switch {
+	case resp.NotModified:
+		if partial != nil || t.cached == nil {
+			return entity.FileMetadata{}, partial, &ports.UpstreamError{Status: "unexpected not modified", StatusCode: resp.StatusCode}
+		}
+		u.cache.refresh(t.source.URL, resp.Header)
+		metadata, err := u.reuseCached(ctx, t, *t.cached)
+		if err != nil {
+			// the cached file is gone or corrupt, download it again
+			t.cached = nil
+			_ = resp.Body.Close()
+			return u.transfer(ctx, t, nil, attempt)
+		}
+		if err := run.limits.admit(metadata); err != nil {
+			_ = u.FileRepository.Delete(ctx, metadata.ID)
+			return entity.FileMetadata{}, nil, err
+		}
+		return metadata, nil, nil
+	case resp.Partial:
+		if partial == nil || resp.Offset != partial.offset {
+			partial.discard()
+			return entity.FileMetadata{}, nil, errRangeMismatch
+		}
+		size = resp.Size
+	default:
+		// Either a fresh download or the upstream ignored the offset: start from zero.
+		partial.discard()
+
+		fw, err := u.FileRepository.Create(ctx)
+		if err != nil {
+			return entity.FileMetadata{}, nil, err
+		}
+		partial = newPartialDownload(fw, resp, t.source.Checksum)
+		size = resp.Size
+	}
+	t.progress.reset(partial.offset, size)
+
+	if err := run.limits.checkDeclaredType(partial.contentType); err != nil {
+		partial.discard()
+		return entity.FileMetadata{}, nil, err
+	}
+	if err := run.limits.checkSize(size); err != nil {
+		partial.discard()
+		return entity.FileMetadata{}, nil, err
+	}
+
+	if partial.resumable {
+		stop := context.AfterFunc(run.pauseCtx, func() { cancelReq(errJobPaused) })
+		defer stop()
+	}
+
+	body := t.progress.Reader(run.bandwidth.Reader(reqCtx, resp.Body))
+	lr := run.limits.reader(body, partial.offset)
+	n, err := io.Copy(partial, lr)
+	partial.offset += n
+	if err != nil {
+		lr.release()
+		if errors.Is(context.Cause(reqCtx), errJobPaused) {
+			err = errJobPaused
+		}
+		if partial.resumable && partial.offset > 0 && !isLimitErr(err) {
+			return entity.FileMetadata{}, partial, err
+		}
+		partial.discard()
+		return entity.FileMetadata{}, nil, err
+	}
+
+	sum, err := partial.digest.verify()
+	if err != nil {
+		lr.release()
+		partial.discard()
+		return entity.FileMetadata{}, nil, err
+	}
+
+	// the sniffed type is checked too when the declared one said nothing
+	metadata := describeFile(partial.sniff.head, partial.contentType, partial.header.Get("Content-Disposition"), t.sourceURL())
+	if err := run.limits.checkType(metadata.MimeType); err != nil {
+		lr.release()
+		partial.discard()
+		return entity.FileMetadata{}, nil, err
+	}
+	metadata.SHA256 = sum
+	u.provenance(&metadata, t, fetchedFile{
+		etag:         partial.etag,
+		lastModified: partial.lastModified,
+		header:       partial.header,
+		startedAt:    partial.startedAt,
 	})
+
+	metadata, err = partial.writer.Commit(ctx, metadata)
+	if err != nil {
+		lr.release()
+		return entity.FileMetadata{}, nil, err
+	}
+	u.cacheFile(ctx, t, partial.header, metadata)
+
+	return metadata, nil, nil
 }
 
-func handleErr(jc *jobCollector, url string, err error) error {
-	jc.addItemError(url, err)
+// jobRun is the state shared by the items of one runJob invocation.
+type jobRun struct {
+	jobID       string
+	options     entity.DownloadOptions
+	retryPolicy entity.RetryPolicy
+	// redirectPolicy applies to every request of the job.
+	redirectPolicy entity.RedirectPolicy
+	// proxy, if set, replaces the proxy routes of the service for the job.
+	proxy *jobProxy
+	// slots is the concurrency budget of the job, shared by items and the
+	// extra connections of segmented downloads.
+	slots *semaphore.Weighted
+	// pauseCtx is done once the job is asked to pause.
+	pauseCtx  context.Context
+	bandwidth *bandwidth.Share
+	limits    *downloadLimits
+}
 
-	if isFatalErr(err) {
-		return err
+// sleep waits for d unless the job ends or is paused first.
+func (run *jobRun) sleep(ctx context.Context, d time.Duration) error {
+	if err := sleepCtx(run.pauseCtx, d); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errJobPaused
 	}
-	return nil
+	return ctx.Err()
 }
 
-func (u *DownloadUseCase) runJob(ctx context.Context, job entity.DownloadJob, urls []string) entity.DownloadJob {
+// runJob downloads the items of job at indexes and stores the job with the
+// progress of its items every progressInterval until it ends.
+func (u *DownloadUseCase) runJob(ctx context.Context, rj *runningJob, job entity.DownloadJob, indexes []int) entity.DownloadJob {
 	var (
 		g errgroup.Group
 	)
 
-	g.SetLimit(10)
-
 	gCtx, gCancel := context.WithCancel(ctx)
 	defer gCancel()
 
-	jc := NewJobCollector(&job)
+	// the caller keeps the job it returned, the items are updated in place
+	job.Items = slices.Clone(job.Items)
+	jc := NewJobCollector(&job, u.events)
+	run := &jobRun{
+		jobID:          job.ID,
+		options:        job.Options,
+		retryPolicy:    mergeRetryPolicy(u.retryPolicy, job.Options.RetryPolicy),
+		redirectPolicy: DefaultRedirectPolicy,
+		slots:          semaphore.NewWeighted(jobConcurrency),
+		pauseCtx:       rj.pauseCtx,
+		bandwidth:      rj.bandwidth,
+		limits:         u.limitsFor(job),
+	}
+	if job.Options.RedirectPolicy != nil {
+		run.redirectPolicy = *job.Options.RedirectPolicy
+	}
+	if job.Options.Proxy != "" {
+		proxyURL, _ := url.Parse(job.Options.Proxy.Reveal())
+		run.proxy = newJobProxy(proxyURL)
+	}
+	defer run.proxy.close()
+
+	stopProgress := u.persistProgress(context.WithoutCancel(ctx), jc)
 
-	for _, url := range urls {
+	// a pause stops scheduling, the items already started run on gCtx
+	schedCtx, schedCancel := context.WithCancel(gCtx)
+	defer schedCancel()
+	stopSched := context.AfterFunc(rj.pauseCtx, schedCancel)
+	defer stopSched()
 
-		url := url
+	for _, index := range indexes {
+
+		index := index
+
+		if err := run.slots.Acquire(schedCtx, 1); err != nil {
+			break
+		}
 
 		g.Go(func() error {
+			defer run.slots.Release(1)
+
 			if err := gCtx.Err(); err != nil {
 				return err
 			}
 
-			resp, err := u.fetchFile(ctx, url)
-			if err != nil {
-				slog.Warn("download %s failed: %v", url, err)
+			item, progress := jc.start(index)
+			t := &itemTask{run: run, index: index, source: item.DownloadSource, progress: progress}
 
-				return handleErr(jc, url, err)
+			result, err := u.downloadItem(ctx, t)
+			if err == nil && t.source.Extract {
+				result, err = u.extractItem(ctx, t, result)
 			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				slog.Warn("download %s upstream error: %v", url, err)
-
-				return handleErr(jc, url, &upstreamError{Status: resp.Status, StatusCode: resp.StatusCode})
-
+			if err != nil && (errors.Is(context.Cause(ctx), errJobCanceled) || errors.Is(err, errJobPaused)) {
+				// an interrupted item is left pending for a resume
+				jc.finish(index, entity.DownloadItem{
+					DownloadSource: item.DownloadSource,
+					State:          entity.ItemPending,
+					BytesReceived:  result.BytesReceived,
+					BytesTotal:     result.BytesTotal,
+				})
+				return nil
 			}
-
-			lr := &io.LimitedReader{R: resp.Body, N: fileMaxSize}
-			var buf bytes.Buffer
-			n, err := buf.ReadFrom(lr)
+			jc.finish(index, result)
 			if err != nil {
-				return handleErr(jc, url, err)
-			}
-			if n > fileMaxSize {
-				return handleErr(jc, url, &upstreamError{Status: resp.Status, StatusCode: http.StatusRequestEntityTooLarge})
-			}
+				slog.Warn("download failed", "url", RedactURL(item.URL), "error", err)
 
-			data := buf.Bytes()
-
-			file := entity.File{
-				Metadata: entity.FileMetadata{
-					MimeType: resp.Header.Get("Content-Type"),
-					Size:     resp.ContentLength,
-				},
-				Data: data,
+				if isFatalErr(err) {
+					return err
+				}
 			}
 
-			fileID, err := u.FileRepository.Create(ctx, file)
-			if err != nil {
-				return handleErr(jc, url, err)
-			}
-
-			jc.addItemSuccess(url, fileID)
 			return nil
 
 		})
 	}
 
 	err := g.Wait()
-
-	if err != nil && isFatalErr(err) {
+	if err == nil {
+		err = gCtx.Err()
+	}
+	stopProgress()
+
+	switch {
+	case errors.Is(context.Cause(ctx), errJobCanceled):
+		job.Status = entity.Canceled
+	case rj.pauseCtx.Err() != nil && hasPending(job, indexes):
+		job.Status = entity.Paused
+	case err != nil && isFatalErr(err):
 		job.Status = entity.Failed
-	} else {
+	default:
 		job.Status = entity.Done
 	}
+	// a paused job and one that failed, such as on its deadline, continue
+	// from where their transfers stopped when resumed
+	if job.Status != entity.Paused && job.Status != entity.Failed {
+		u.dropCheckpoints(&job)
+	}
 
-	_ = u.DownloadJobRepository.Update(ctx, job)
+	// the job context is already done when the job timed out or was canceled
+	_ = u.DownloadJobRepository.Update(context.WithoutCancel(ctx), job)
+	u.publishStatus(job)
+	go u.notify(job)
 
 	return job
 }
 
-func (u *DownloadUseCase) StartJob(rCtx context.Context, duration time.Duration, urls []string) (entity.DownloadJob, error) {
+// persistProgress stores and publishes a snapshot of the job every
+// progressInterval until the returned stop is called. Once stop returns no
+// more snapshots are stored.
+func (u *DownloadUseCase) persistProgress(ctx context.Context, jc *jobCollector) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				job := jc.snapshot()
+				if err := u.DownloadJobRepository.Update(ctx, job); err != nil {
+					slog.Warn("failed to store job progress", "error", err)
+				}
+				jc.publishProgress(job)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func (u *DownloadUseCase) publishStatus(job entity.DownloadJob) {
+	u.events.publish(entity.JobEvent{
+		JobID:  job.ID,
+		Type:   entity.EventJobStatus,
+		Status: job.Status,
+	})
+}
+
+func hasPending(job entity.DownloadJob, indexes []int) bool {
+	for _, index := range indexes {
+		if job.Items[index].State == entity.ItemPending {
+			return true
+		}
+	}
+	return false
+}
+
+// launch runs job in the background, detached from the request that started
+// it, and registers it so other requests can control it while it runs.
+func (u *DownloadUseCase) launch(rCtx context.Context, job entity.DownloadJob, indexes []int, duration time.Duration) error {
+	parentCtx, cancel := context.WithCancelCause(context.WithoutCancel(rCtx))
+	ctx, cancelTimeout := context.WithTimeout(parentCtx, duration)
+	pauseCtx, pause := context.WithCancel(context.Background())
+
+	rj := &runningJob{
+		cancel:   cancel,
+		pause:    pause,
+		pauseCtx: pauseCtx,
+		done:     make(chan struct{}),
+	}
+	if !u.running.register(job.ID, rj) {
+		pause()
+		cancelTimeout()
+		cancel(nil)
+		return ErrJobRunning
+	}
+	rj.bandwidth = u.bandwidth.Join(job.Options.MaxBandwidth)
+	u.publishStatus(job)
+
+	go func() {
+		defer close(rj.done)
+		defer u.running.unregister(job.ID)
+		defer rj.bandwidth.Leave()
+		defer pause()
+		defer cancel(nil)
+		defer cancelTimeout()
+
+		_ = u.runJob(ctx, rj, job, indexes)
+	}()
+
+	return nil
+}
+
+func (u *DownloadUseCase) StartJob(rCtx context.Context, duration time.Duration, sources []entity.DownloadSource, options entity.DownloadOptions) (entity.DownloadJob, error) {
 	parentCtx := context.WithoutCancel(rCtx) // detach from parent request context
-	ctx, cancel := context.WithTimeout(parentCtx, duration)
 
 	jobEntity := entity.DownloadJob{
 		Status:    entity.Process,
 		Timeout:   duration,
+		Options:   options,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-	createdJob, err := u.DownloadJobRepository.Create(ctx, jobEntity)
+	indexes := make([]int, len(sources))
+	for i, source := range sources {
+		jobEntity.Items = append(jobEntity.Items, entity.DownloadItem{
+			DownloadSource: source,
+			State:          entity.ItemPending,
+			BytesTotal:     -1,
+		})
+		indexes[i] = i
+	}
+	createdJob, err := u.DownloadJobRepository.Create(parentCtx, jobEntity)
 	if err != nil {
-		cancel()
 		return entity.DownloadJob{}, err
 	}
 
-	go func() {
-		defer cancel()
-		_ = u.runJob(ctx, createdJob, urls)
-	}()
+	if err := u.launch(rCtx, createdJob, indexes, duration); err != nil {
+		return entity.DownloadJob{}, err
+	}
 
 	return createdJob, nil
 }
 
-func (u *DownloadUseCase) GetJob(rCtx context.Context, jobID string) (entity.DownloadJob, error) {
+// ResumeJob runs every item of a paused, failed or finished job that has not
+// been downloaded yet with a fresh deadline: the ones never started, the
+// failed ones and the ones interrupted by a pause. Transfers cut off by a
+// pause or by the deadline of a failed job continue from their checkpoint
+// while it is kept, the others start over. A canceled job is not resumed.
+func (u *DownloadUseCase) ResumeJob(rCtx context.Context, jobID string) (entity.DownloadJob, error) {
+	if _, running := u.running.get(jobID); running {
+		return entity.DownloadJob{}, ErrJobRunning
+	}
+
+	job, err := u.DownloadJobRepository.Get(rCtx, jobID)
+	if err != nil {
+		return entity.DownloadJob{}, err
+	}
+	if job.Status == entity.Process {
+		return entity.DownloadJob{}, ErrJobRunning
+	}
+	if job.Status == entity.Canceled {
+		return entity.DownloadJob{}, ErrJobCanceled
+	}
+
+	var indexes []int
+	for i, item := range job.Items {
+		if item.State == entity.ItemDone {
+			continue
+		}
+		job.Items[i] = entity.DownloadItem{
+			DownloadSource: item.DownloadSource,
+			State:          entity.ItemPending,
+			BytesReceived:  item.BytesReceived,
+			BytesTotal:     item.BytesTotal,
+		}
+		indexes = append(indexes, i)
+	}
+	if len(indexes) == 0 {
+		return job, nil
+	}
+
+	job.Status = entity.Process
+	if err := u.DownloadJobRepository.Update(rCtx, job); err != nil {
+		return entity.DownloadJob{}, err
+	}
+
+	if err := u.launch(rCtx, job, indexes, job.Timeout); err != nil {
+		return entity.DownloadJob{}, err
+	}
+
+	return job, nil
+}
+
+// CancelJob stops a running job, aborting its in-flight requests, and waits
+// until the job is stored as Canceled with the items finished so far. A
+// paused job is canceled right away.
+func (u *DownloadUseCase) CancelJob(rCtx context.Context, jobID string) (entity.DownloadJob, error) {
+	rj, running := u.running.get(jobID)
+	if !running {
+		job, err := u.DownloadJobRepository.Get(rCtx, jobID)
+		if err != nil {
+			return entity.DownloadJob{}, err
+		}
+		if job.Status != entity.Paused {
+			return entity.DownloadJob{}, ErrJobNotRunning
+		}
+
+		job.Status = entity.Canceled
+		u.dropCheckpoints(&job)
+		if err := u.DownloadJobRepository.Update(rCtx, job); err != nil {
+			return entity.DownloadJob{}, err
+		}
+		u.publishStatus(job)
+		go u.notify(job)
+		return job, nil
+	}
+
+	rj.cancel(errJobCanceled)
+
+	return u.waitJob(rCtx, rj, jobID)
+}
+
+// PauseJob stops scheduling new items of a running job. Items in flight
+// either finish or, when their upstream supports ranges, are checkpointed.
+// It waits until the job is stored as Paused.
+func (u *DownloadUseCase) PauseJob(rCtx context.Context, jobID string) (entity.DownloadJob, error) {
+	rj, running := u.running.get(jobID)
+	if !running {
+		if _, err := u.DownloadJobRepository.Get(rCtx, jobID); err != nil {
+			return entity.DownloadJob{}, err
+		}
+		return entity.DownloadJob{}, ErrJobNotRunning
+	}
+
+	rj.pause()
+
+	return u.waitJob(rCtx, rj, jobID)
+}
+
+func (u *DownloadUseCase) waitJob(rCtx context.Context, rj *runningJob, jobID string) (entity.DownloadJob, error) {
+	select {
+	case <-rj.done:
+	case <-rCtx.Done():
+		return entity.DownloadJob{}, rCtx.Err()
+	}
+
 	return u.DownloadJobRepository.Get(rCtx, jobID)
 }
 
-func (u *DownloadUseCase) GetFile(rCtx context.Context, jobID, fileID string) (entity.File, error) {
-	return u.FileRepository.Get(rCtx, fileID)
+// GetJob returns the stored job with its callback deliveries, and the live
+// throughput while it is running.
+func (u *DownloadUseCase) GetJob(rCtx context.Context, jobID string) (entity.DownloadJob, error) {
+	job, err := u.DownloadJobRepository.Get(rCtx, jobID)
+	if err != nil {
+		return entity.DownloadJob{}, err
+	}
+
+	job.Deliveries = u.webhooks.get(jobID)
+
+	if rj, running := u.running.get(jobID); running {
+		job.Throughput = rj.bandwidth.Rate()
+	}
+	return job, nil
+}
+
+// JobEvents streams the events of a job published after lastEventID and
+// closes the channel once the job ended or ctx is done. A job that ended
+// before its events were kept yields a single status event.
+func (u *DownloadUseCase) JobEvents(ctx context.Context, jobID string, lastEventID int64) (<-chan entity.JobEvent, error) {
+	job, err := u.DownloadJobRepository.Get(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan entity.JobEvent)
+	go func() {
+		defer close(ch)
+
+		for {
+			events, changed, ended, exists := u.events.since(jobID, lastEventID)
+			if !exists {
+				status := entity.JobEvent{JobID: jobID, Type: entity.EventJobStatus, Status: job.Status}
+				if !status.Terminal() {
+					u.events.watch(jobID)
+					continue
+				}
+				select {
+				case ch <- status:
+				case <-ctx.Done():
+				}
+				return
+			}
+
+			for _, event := range events {
+				select {
+				case ch <- event:
+					lastEventID = event.ID
+				case <-ctx.Done():
+					return
+				}
+			}
+			if ended {
+				return
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+func (u *DownloadUseCase) GetFile(rCtx context.Context, jobID, fileID string) (io.ReadSeekCloser, entity.FileMetadata, error) {
+	return u.FileRepository.Open(rCtx, fileID)
+}
+
+func (u *DownloadUseCase) GetFileMetadata(rCtx context.Context, jobID, fileID string) (entity.FileMetadata, error) {
+	return u.FileRepository.Metadata(rCtx, fileID)
 }
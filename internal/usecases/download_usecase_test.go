@@ -1,18 +1,86 @@
 package usecases_test
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/textproto"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"gin-quickstart/internal/domain/entity"
 	"gin-quickstart/internal/domain/ports/mocks"
+	router "gin-quickstart/internal/transport/http"
+	"gin-quickstart/internal/transport/http/handlers"
 	"gin-quickstart/internal/usecases"
+	"gin-quickstart/pkg/egress"
+	"gin-quickstart/pkg/hostlimiter"
+	"gin-quickstart/pkg/netguard"
 
 	"github.com/golang/mock/gomock"
 )
 
+type nopReadSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopReadSeekCloser) Close() error { return nil }
+
+func sources(urls ...string) []entity.DownloadSource {
+	sources := make([]entity.DownloadSource, len(urls))
+	for i, url := range urls {
+		sources[i] = entity.DownloadSource{URL: url}
+	}
+	return sources
+}
+
+// loopback lets the use case reach the httptest servers, blocked by default.
+var loopback = netguard.WithAllowCIDRs(netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128"))
+
+func newUseCase(options ...usecases.Option) *usecases.DownloadUseCase {
+	return usecases.NewDownloadUseCase(append([]usecases.Option{usecases.WithNetGuard(netguard.New(loopback))}, options...)...)
+}
+
+// waitJob polls the job repository until the job leaves the Process status.
+func waitJob(t *testing.T, u *usecases.DownloadUseCase, jobID string) entity.DownloadJob {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := u.GetJob(context.Background(), jobID)
+		if err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		if job.Status != entity.Process {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for job %s", jobID)
+	return entity.DownloadJob{}
+}
+
 func TestDownloadUseCase_GetJob_Success(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -20,7 +88,7 @@ func TestDownloadUseCase_GetJob_Success(t *testing.T) {
 	jobRepo := mocks.NewMockDownloadJobRepository(ctrl)
 	fileRepo := mocks.NewMockFileRepository(ctrl)
 
-	u := usecases.NewDownloadUseCase()
+	u := newUseCase()
 	u.DownloadJobRepository = jobRepo
 	u.FileRepository = fileRepo
 
@@ -47,7 +115,7 @@ func TestDownloadUseCase_GetJob_Error(t *testing.T) {
 	jobRepo := mocks.NewMockDownloadJobRepository(ctrl)
 	fileRepo := mocks.NewMockFileRepository(ctrl)
 
-	u := usecases.NewDownloadUseCase()
+	u := newUseCase()
 	u.DownloadJobRepository = jobRepo
 	u.FileRepository = fileRepo
 
@@ -69,26 +137,32 @@ func TestDownloadUseCase_GetFile_Success(t *testing.T) {
 	jobRepo := mocks.NewMockDownloadJobRepository(ctrl)
 	fileRepo := mocks.NewMockFileRepository(ctrl)
 
-	u := usecases.NewDownloadUseCase()
+	u := newUseCase()
 	u.DownloadJobRepository = jobRepo
 	u.FileRepository = fileRepo
 
-	want := entity.File{
-		Metadata: entity.FileMetadata{ID: "file-1", MimeType: "text/plain", Size: 3},
-		Data:     []byte("abc"),
-	}
+	want := entity.FileMetadata{ID: "file-1", MimeType: "text/plain", Size: 3}
 
 	fileRepo.EXPECT().
-		Get(gomock.Any(), "file-1").
-		Return(want, nil).
+		Open(gomock.Any(), "file-1").
+		Return(nopReadSeekCloser{strings.NewReader("abc")}, want, nil).
 		Times(1)
 
-	got, err := u.GetFile(context.Background(), "job-ignored", "file-1")
+	content, metadata, err := u.GetFile(context.Background(), "job-ignored", "file-1")
 	if err != nil {
 		t.Fatalf("expected nil err, got %v", err)
 	}
-	if string(got.Data) != "abc" {
-		t.Fatalf("expected data abc, got %q", string(got.Data))
+	defer content.Close()
+
+	if metadata.ID != "file-1" {
+		t.Fatalf("expected file-1, got %q", metadata.ID)
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		t.Fatalf("expected nil read err, got %v", err)
+	}
+	if string(data) != "abc" {
+		t.Fatalf("expected data abc, got %q", string(data))
 	}
 }
 
@@ -99,16 +173,16 @@ func TestDownloadUseCase_GetFile_Error(t *testing.T) {
 	jobRepo := mocks.NewMockDownloadJobRepository(ctrl)
 	fileRepo := mocks.NewMockFileRepository(ctrl)
 
-	u := usecases.NewDownloadUseCase()
+	u := newUseCase()
 	u.DownloadJobRepository = jobRepo
 	u.FileRepository = fileRepo
 
 	fileRepo.EXPECT().
-		Get(gomock.Any(), "file-1").
-		Return(entity.File{}, errors.New("not found")).
+		Open(gomock.Any(), "file-1").
+		Return(nil, entity.FileMetadata{}, errors.New("not found")).
 		Times(1)
 
-	_, err := u.GetFile(context.Background(), "job-ignored", "file-1")
+	_, _, err := u.GetFile(context.Background(), "job-ignored", "file-1")
 	if err == nil {
 		t.Fatalf("expected err, got nil")
 	}
@@ -121,7 +195,7 @@ func TestDownloadUseCase_StartJob_Success(t *testing.T) {
 	jobRepo := mocks.NewMockDownloadJobRepository(ctrl)
 	fileRepo := mocks.NewMockFileRepository(ctrl)
 
-	u := usecases.NewDownloadUseCase()
+	u := newUseCase()
 	u.DownloadJobRepository = jobRepo
 	u.FileRepository = fileRepo
 
@@ -161,7 +235,7 @@ func TestDownloadUseCase_StartJob_Success(t *testing.T) {
 		}).
 		Times(1)
 
-	got, err := u.StartJob(context.Background(), 50*time.Millisecond, nil) // nil/empty => no HTTP
+	got, err := u.StartJob(context.Background(), 50*time.Millisecond, nil, entity.DownloadOptions{}) // nil/empty => no HTTP
 	if err != nil {
 		t.Fatalf("expected nil err, got %v", err)
 	}
@@ -184,7 +258,7 @@ func TestDownloadUseCase_StartJob_Error(t *testing.T) {
 	jobRepo := mocks.NewMockDownloadJobRepository(ctrl)
 	fileRepo := mocks.NewMockFileRepository(ctrl)
 
-	u := usecases.NewDownloadUseCase()
+	u := newUseCase()
 	u.DownloadJobRepository = jobRepo
 	u.FileRepository = fileRepo
 
@@ -193,8 +267,2054 @@ func TestDownloadUseCase_StartJob_Error(t *testing.T) {
 		Return(entity.DownloadJob{}, errors.New("create failed")).
 		Times(1)
 
-	_, err := u.StartJob(context.Background(), 50*time.Millisecond, nil)
+	_, err := u.StartJob(context.Background(), 50*time.Millisecond, nil, entity.DownloadOptions{})
 	if err == nil {
 		t.Fatalf("expected err, got nil")
 	}
 }
+
+func TestDownloadUseCase_StartJob_StreamsIntoRepository(t *testing.T) {
+	payload := strings.Repeat("0123456789", 100_000)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, payload)
+	}))
+	defer srv.Close()
+
+	u := newUseCase()
+
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.URL+"/a.txt"), entity.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	job := waitJob(t, u, created.ID)
+	if job.Status != entity.Done {
+		t.Fatalf("expected Done, got %v", job.Status)
+	}
+	if len(job.Items) != 1 || job.Items[0].FileID == "" {
+		t.Fatalf("expected one stored item, got %+v", job.Items)
+	}
+
+	content, metadata, err := u.GetFile(context.Background(), job.ID, job.Items[0].FileID)
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	defer content.Close()
+
+	if metadata.Size != int64(len(payload)) {
+		t.Fatalf("expected size %d, got %d", len(payload), metadata.Size)
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		t.Fatalf("expected nil read err, got %v", err)
+	}
+	if string(data) != payload {
+		t.Fatalf("stored content does not match upstream payload")
+	}
+}
+
+func TestDownloadUseCase_StartJob_ResumesInterruptedTransfer(t *testing.T) {
+	payload := strings.Repeat("abcdefghij", 50_000)
+	modTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var (
+		requests int
+		ranges   []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		ranges = append(ranges, r.Header.Get("Range"))
+
+		w.Header().Set("ETag", `"v1"`)
+		if requests == 1 {
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+			_, _ = io.WriteString(w, payload[:len(payload)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "a.txt", modTime, strings.NewReader(payload))
+	}))
+	defer srv.Close()
+
+	u := newUseCase()
+
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.URL+"/a.txt"), entity.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	job := waitJob(t, u, created.ID)
+	if len(job.Items) != 1 || job.Items[0].FileID == "" {
+		t.Fatalf("expected one stored item, got %+v", job.Items)
+	}
+	if requests != 2 || ranges[1] == "" {
+		t.Fatalf("expected a second ranged request, got ranges %q", ranges)
+	}
+
+	content, _, err := u.GetFile(context.Background(), job.ID, job.Items[0].FileID)
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	defer content.Close()
+
+	data, _ := io.ReadAll(content)
+	if string(data) != payload {
+		t.Fatalf("resumed content does not match upstream payload (%d of %d bytes)", len(data), len(payload))
+	}
+}
+
+func TestDownloadUseCase_StartJob_SegmentedDownload(t *testing.T) {
+	payload := strings.Repeat("0123456789abcdef", 64<<10)
+	modTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var (
+		mu     sync.Mutex
+		ranges []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+
+		http.ServeContent(w, r, "big.bin", modTime, strings.NewReader(payload))
+	}))
+	defer srv.Close()
+
+	u := newUseCase()
+
+	options := entity.DownloadOptions{Segments: 4, MinSegmentSize: 64 << 10}
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.URL+"/big.bin"), options)
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	job := waitJob(t, u, created.ID)
+	if len(job.Items) != 1 || job.Items[0].FileID == "" {
+		t.Fatalf("expected one stored item, got %+v", job.Items)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// one probe plus four segments
+	if len(ranges) != 5 {
+		t.Fatalf("expected 5 ranged requests, got %q", ranges)
+	}
+
+	content, _, err := u.GetFile(context.Background(), job.ID, job.Items[0].FileID)
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	defer content.Close()
+
+	data, _ := io.ReadAll(content)
+	if string(data) != payload {
+		t.Fatalf("reassembled content does not match upstream payload")
+	}
+}
+
+func TestDownloadUseCase_StartJob_RetriesRetryableStatus(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			// a day is far past the job deadline, the policy caps it
+			w.Header().Set("Retry-After", "86400")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	u := newUseCase()
+
+	options := entity.DownloadOptions{
+		RetryPolicy: &entity.RetryPolicy{MaxAttempts: 3, BaseBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond},
+	}
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.URL), options)
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	job := waitJob(t, u, created.ID)
+	if len(job.Items) != 1 {
+		t.Fatalf("expected one item, got %+v", job.Items)
+	}
+
+	item := job.Items[0]
+	if item.Error != nil || item.FileID == "" {
+		t.Fatalf("expected item to succeed on retry, got %+v", item)
+	}
+	if len(item.Attempts) != 2 {
+		t.Fatalf("expected 2 attempts, got %+v", item.Attempts)
+	}
+	if first := item.Attempts[0]; first.StatusCode != http.StatusServiceUnavailable || first.ErrorCode != entity.ErrorHTTP {
+		t.Fatalf("expected first attempt to record 503 HTTP_ERROR, got %+v", first)
+	}
+	if wait := item.Attempts[1].StartedAt.Sub(item.Attempts[0].StartedAt); wait > time.Second {
+		t.Fatalf("expected Retry-After capped at MaxBackoff, waited %v", wait)
+	}
+}
+
+func TestDownloadUseCase_StartJob_DisablesJitter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	// the service randomises the whole backoff, the job turns that off
+	fullJitter := 1.0
+	u := newUseCase(usecases.WithRetryPolicy(entity.RetryPolicy{
+		MaxAttempts:          3,
+		BaseBackoff:          50 * time.Millisecond,
+		MaxBackoff:           50 * time.Millisecond,
+		Jitter:               &fullJitter,
+		RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+	}))
+
+	noJitter := 0.0
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.URL), entity.DownloadOptions{
+		RetryPolicy: &entity.RetryPolicy{Jitter: &noJitter},
+	})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	item := waitJob(t, u, created.ID).Items[0]
+	if len(item.Attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %+v", item.Attempts)
+	}
+	for i := 1; i < len(item.Attempts); i++ {
+		if wait := item.Attempts[i].StartedAt.Sub(item.Attempts[i-1].StartedAt); wait < 50*time.Millisecond {
+			t.Fatalf("expected the full backoff without jitter, waited %v", wait)
+		}
+	}
+}
+
+func TestDownloadUseCase_CancelJob(t *testing.T) {
+	slowStarted := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(slowStarted)
+			<-r.Context().Done()
+			return
+		}
+		_, _ = io.WriteString(w, "fast")
+	}))
+	defer srv.Close()
+
+	u := newUseCase()
+
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.URL+"/fast", srv.URL+"/slow"), entity.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	<-slowStarted
+	time.Sleep(200 * time.Millisecond) // let the fast item finish
+
+	job, err := u.CancelJob(context.Background(), created.ID)
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	if job.Status != entity.Canceled {
+		t.Fatalf("expected Canceled, got %v", job.Status)
+	}
+	if len(job.Items) != 2 || job.Items[0].State != entity.ItemDone || job.Items[0].FileID == "" {
+		t.Fatalf("expected the finished item to be kept, got %+v", job.Items)
+	}
+	if job.Items[1].State != entity.ItemPending || job.Items[1].Error != nil {
+		t.Fatalf("expected the interrupted item to be pending, got %+v", job.Items[1])
+	}
+
+	if _, err := u.CancelJob(context.Background(), created.ID); !errors.Is(err, usecases.ErrJobNotRunning) {
+		t.Fatalf("expected ErrJobNotRunning, got %v", err)
+	}
+	// the end of a canceled job was reported, it never runs again
+	if _, err := u.ResumeJob(context.Background(), created.ID); !errors.Is(err, usecases.ErrJobCanceled) {
+		t.Fatalf("expected ErrJobCanceled, got %v", err)
+	}
+}
+
+func TestDownloadUseCase_ReportsItemProgress(t *testing.T) {
+	payload := strings.Repeat("abcdefghij", 1_000)
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+			_, _ = io.WriteString(w, payload[:len(payload)/2])
+			w.(http.Flusher).Flush()
+			<-release
+			_, _ = io.WriteString(w, payload[len(payload)/2:])
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	u := newUseCase()
+
+	urls := []string{srv.URL + "/slow", srv.URL + "/missing"}
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(urls...), entity.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	if len(created.Items) != 2 || created.Items[0].State != entity.ItemPending {
+		t.Fatalf("expected pending items, got %+v", created.Items)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := u.GetJob(context.Background(), created.ID)
+		if err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		item := job.Items[0]
+		if item.State == entity.ItemDownloading && item.BytesReceived == int64(len(payload)/2) {
+			if item.BytesTotal != int64(len(payload)) {
+				t.Fatalf("expected total %d, got %d", len(payload), item.BytesTotal)
+			}
+			if job.Items[1].State != entity.ItemFailed {
+				t.Fatalf("expected the missing item to fail, got %+v", job.Items[1])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no live progress reported, got %+v", job.Items)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	close(release)
+
+	job := waitJob(t, u, created.ID)
+	for i, item := range job.Items {
+		if item.URL != urls[i] {
+			t.Fatalf("expected items in request order, got %+v", job.Items)
+		}
+	}
+	if item := job.Items[0]; item.State != entity.ItemDone || item.BytesReceived != int64(len(payload)) {
+		t.Fatalf("expected the item to be done, got %+v", item)
+	}
+}
+
+func TestDownloadUseCase_JobEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, "hello")
+	}))
+	defer srv.Close()
+
+	u := newUseCase()
+
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.URL+"/a.txt", srv.URL+"/missing"), entity.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	collect := func(lastEventID int64) []entity.JobEvent {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		ch, err := u.JobEvents(ctx, created.ID, lastEventID)
+		if err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		var events []entity.JobEvent
+		for event := range ch {
+			events = append(events, event)
+		}
+		if len(events) == 0 || !events[len(events)-1].Terminal() {
+			t.Fatalf("expected the stream to end with the job, got %+v", events)
+		}
+		return events
+	}
+
+	events := collect(0)
+	if events[0].Type != entity.EventJobStatus || events[0].Status != entity.Process {
+		t.Fatalf("expected the job start first, got %+v", events[0])
+	}
+	counts := make(map[entity.JobEventType]int)
+	for i, event := range events {
+		if i > 0 && event.ID <= events[i-1].ID {
+			t.Fatalf("expected increasing event IDs, got %+v", events)
+		}
+		counts[event.Type]++
+	}
+	if counts[entity.EventItemStarted] != 2 || counts[entity.EventItemDone] != 1 || counts[entity.EventItemFailed] != 1 {
+		t.Fatalf("expected item events for both items, got %v", counts)
+	}
+	if last := events[len(events)-1]; last.Status != entity.Done {
+		t.Fatalf("expected Done, got %v", last.Status)
+	}
+
+	resumed := collect(events[1].ID)
+	if len(resumed) != len(events)-2 || resumed[0].ID != events[2].ID {
+		t.Fatalf("expected the events after %d, got %+v", events[1].ID, resumed)
+	}
+
+	if _, err := u.JobEvents(context.Background(), "unknown", 0); err == nil {
+		t.Fatalf("expected error for unknown job")
+	}
+}
+
+func TestDownloadUseCase_SignedWebhook(t *testing.T) {
+	const secret = "s3cr3t"
+
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+	}))
+	defer files.Close()
+
+	var calls atomic.Int32
+	received := make(chan map[string]any, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(r.Header.Get(usecases.HeaderWebhookTimestamp) + "."))
+		mac.Write(body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.Header.Get(usecases.HeaderWebhookSignature) != want {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var payload map[string]any
+		_ = json.Unmarshal(body, &payload)
+		received <- payload
+	}))
+	defer hook.Close()
+
+	u := newUseCase(usecases.WithWebhookRetryPolicy(entity.RetryPolicy{
+		MaxAttempts:          3,
+		BaseBackoff:          10 * time.Millisecond,
+		MaxBackoff:           10 * time.Millisecond,
+		RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+	}))
+
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(files.URL+"/a.txt"), entity.DownloadOptions{
+		Callback: &entity.Callback{URL: hook.URL, Secret: secret},
+	})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	select {
+	case payload := <-received:
+		if payload["id"] != created.ID || payload["status"] != "DONE" {
+			t.Fatalf("expected the finished job, got %v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("webhook not delivered")
+	}
+
+	// the delivery is logged right after the callback responds
+	var job entity.DownloadJob
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if job, err = u.GetJob(context.Background(), created.ID); err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		if len(job.Deliveries) == 2 {
+			break
+		}
+	}
+	if len(job.Deliveries) != 2 {
+		t.Fatalf("expected two deliveries, got %+v", job.Deliveries)
+	}
+	if d := job.Deliveries[0]; d.StatusCode != http.StatusServiceUnavailable || d.Error == "" {
+		t.Fatalf("expected the first delivery to fail, got %+v", d)
+	}
+	if d := job.Deliveries[1]; d.StatusCode != http.StatusOK || d.Error != "" || d.JobStatus != entity.Done {
+		t.Fatalf("expected the second delivery to succeed, got %+v", d)
+	}
+}
+
+func TestDownloadUseCase_VerifiesChecksums(t *testing.T) {
+	payload := strings.Repeat("checksum", 1_000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "a.txt", time.Time{}, strings.NewReader(payload))
+	}))
+	defer srv.Close()
+
+	sha256Sum := sha256.Sum256([]byte(payload))
+	sha512Sum := sha512.Sum512([]byte(payload))
+
+	u := newUseCase()
+
+	created, err := u.StartJob(context.Background(), 5*time.Second, []entity.DownloadSource{
+		{URL: srv.URL + "/plain"},
+		{URL: srv.URL + "/ok", Checksum: &entity.Checksum{Algorithm: entity.DigestSHA512, Value: hex.EncodeToString(sha512Sum[:])}},
+		{URL: srv.URL + "/bad", Checksum: &entity.Checksum{Algorithm: entity.DigestMD5, Value: strings.Repeat("0", 32)}},
+	}, entity.DownloadOptions{Segments: 4, MinSegmentSize: 1 << 10})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	job := waitJob(t, u, created.ID)
+	want := hex.EncodeToString(sha256Sum[:])
+	for _, item := range job.Items[:2] {
+		if item.State != entity.ItemDone || item.SHA256 != want {
+			t.Fatalf("expected a stored file with sha256 %s, got %+v", want, item)
+		}
+	}
+	if item := job.Items[2]; item.Error == nil || item.Error.Code != entity.ErrorChecksumMismatch || item.FileID != "" {
+		t.Fatalf("expected a checksum mismatch, got %+v", item)
+	}
+
+	content, metadata, err := u.GetFile(context.Background(), job.ID, job.Items[0].FileID)
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	defer content.Close()
+	if metadata.SHA256 != want {
+		t.Fatalf("expected sha256 %s in the metadata, got %s", want, metadata.SHA256)
+	}
+}
+
+func TestDownloadUseCase_DeduplicatesContent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "same bytes")
+	}))
+	defer srv.Close()
+
+	u := newUseCase()
+
+	var fileIDs []string
+	for range 2 {
+		created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.URL+"/a.txt"), entity.DownloadOptions{})
+		if err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		job := waitJob(t, u, created.ID)
+		fileIDs = append(fileIDs, job.Items[0].FileID)
+	}
+	if fileIDs[0] == "" || fileIDs[0] == fileIDs[1] {
+		t.Fatalf("expected a file ID per job, got %v", fileIDs)
+	}
+
+	if err := u.FileRepository.Delete(context.Background(), fileIDs[0]); err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	if _, _, err := u.FileRepository.Open(context.Background(), fileIDs[0]); err == nil {
+		t.Fatalf("expected the deleted file to be gone")
+	}
+
+	content, _, err := u.FileRepository.Open(context.Background(), fileIDs[1])
+	if err != nil {
+		t.Fatalf("expected the shared content to outlive the deleted file, got %v", err)
+	}
+	defer content.Close()
+
+	data, _ := io.ReadAll(content)
+	if string(data) != "same bytes" {
+		t.Fatalf("unexpected content %q", data)
+	}
+}
+
+func TestDownloadUseCase_HTTPCache(t *testing.T) {
+	var fresh, stale, notModified atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		switch r.URL.Path {
+		case "/fresh":
+			fresh.Add(1)
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		case "/stale":
+			stale.Add(1)
+			w.Header().Set("Cache-Control", "no-cache")
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				notModified.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		_, _ = io.WriteString(w, "cached "+r.URL.Path)
+	}))
+	defer srv.Close()
+
+	u := newUseCase(usecases.WithHTTPCache(8))
+
+	run := func(options entity.DownloadOptions) entity.DownloadJob {
+		created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.URL+"/fresh", srv.URL+"/stale"), options)
+		if err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		return waitJob(t, u, created.ID)
+	}
+
+	first := run(entity.DownloadOptions{})
+	for _, item := range first.Items {
+		if item.State != entity.ItemDone || item.CacheHit {
+			t.Fatalf("expected a download, got %+v", item)
+		}
+	}
+
+	second := run(entity.DownloadOptions{})
+	if item := second.Items[0]; !item.CacheHit || item.Revalidated || fresh.Load() != 1 {
+		t.Fatalf("expected a fresh hit without a request, got %+v after %d requests", item, fresh.Load())
+	}
+	if item := second.Items[1]; !item.CacheHit || !item.Revalidated || notModified.Load() != 1 {
+		t.Fatalf("expected a revalidated hit, got %+v", item)
+	}
+	for i, item := range second.Items {
+		if item.FileID == first.Items[i].FileID || item.SHA256 != first.Items[i].SHA256 {
+			t.Fatalf("expected a new file with the same content, got %+v", item)
+		}
+	}
+
+	content, _, err := u.GetFile(context.Background(), second.ID, second.Items[1].FileID)
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	defer content.Close()
+	if data, _ := io.ReadAll(content); string(data) != "cached /stale" {
+		t.Fatalf("unexpected content %q", data)
+	}
+
+	third := run(entity.DownloadOptions{BypassCache: true})
+	for _, item := range third.Items {
+		if item.CacheHit {
+			t.Fatalf("expected the cache to be bypassed, got %+v", item)
+		}
+	}
+	if fresh.Load() != 2 || stale.Load() != 3 || notModified.Load() != 1 {
+		t.Fatalf("unexpected upstream requests: fresh %d, stale %d, not modified %d", fresh.Load(), stale.Load(), notModified.Load())
+	}
+}
+
+func TestDownloadUseCase_HTTPCache_AppliesRedirectPolicy(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		_, _ = io.WriteString(w, "mirrored")
+	}))
+	defer mirror.Close()
+	_, port, _ := net.SplitHostPort(mirror.Listener.Addr().String())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://localhost:"+port+"/file", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	u := newUseCase(usecases.WithHTTPCache(8))
+
+	run := func(policy *entity.RedirectPolicy) entity.DownloadItem {
+		created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.URL+"/moved"), entity.DownloadOptions{RedirectPolicy: policy})
+		if err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		return waitJob(t, u, created.ID).Items[0]
+	}
+
+	if item := run(nil); item.State != entity.ItemDone || item.CacheHit {
+		t.Fatalf("expected a download, got %+v", item)
+	}
+
+	// the cached file came through another host, which this job refuses
+	item := run(&entity.RedirectPolicy{MaxRedirects: 10})
+	if item.CacheHit || item.Error == nil || item.Error.Code != entity.ErrorRedirectNotAllowed {
+		t.Fatalf("expected %s instead of a cache hit, got %+v", entity.ErrorRedirectNotAllowed, item)
+	}
+
+	if item := run(nil); !item.CacheHit || !strings.HasPrefix(item.FinalURL, "http://localhost:") {
+		t.Fatalf("expected a cache hit, got %+v", item)
+	}
+}
+
+func TestDownloadUseCase_EnforcesLimits(t *testing.T) {
+	var bodiesSent atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/big":
+			w.Header().Set("Content-Length", "2000")
+		case "/chunked":
+			// no Content-Length, only the stream reveals the size
+			w.Header().Set("Content-Type", "text/plain")
+			for range 20 {
+				_, _ = io.WriteString(w, strings.Repeat("c", 100))
+				w.(http.Flusher).Flush()
+			}
+			return
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+		case "/mid":
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = io.WriteString(w, strings.Repeat("m", 800))
+			return
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = io.WriteString(w, strings.Repeat("s", 100))
+			return
+		}
+		bodiesSent.Add(1)
+		_, _ = io.WriteString(w, strings.Repeat("b", 2000))
+	}))
+	defer srv.Close()
+
+	u := newUseCase(usecases.WithMaxFileSize(500))
+
+	urls := []string{srv.URL + "/big", srv.URL + "/chunked", srv.URL + "/image", srv.URL + "/mid", srv.URL + "/small1", srv.URL + "/small2"}
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(urls...), entity.DownloadOptions{
+		MaxFileSize:     1000,
+		MaxJobBytes:     150,
+		DeniedMimeTypes: []string{"image/*"},
+	})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	job := waitJob(t, u, created.ID)
+
+	code := func(item entity.DownloadItem) entity.DownloadItemErrorCode {
+		if item.Error == nil {
+			return ""
+		}
+		return item.Error.Code
+	}
+	want := []entity.DownloadItemErrorCode{
+		entity.ErrorTooLarge,
+		entity.ErrorTooLarge,
+		entity.ErrorMimeNotAllowed,
+		entity.ErrorTooLarge, // the service ceiling is below what the job asked for
+	}
+	for i, w := range want {
+		if got := code(job.Items[i]); got != w {
+			t.Fatalf("item %d: expected %s, got %s", i, w, got)
+		}
+	}
+
+	small := []entity.DownloadItemErrorCode{code(job.Items[4]), code(job.Items[5])}
+	if !(small[0] == "" && small[1] == entity.ErrorJobQuotaExceeded) && !(small[0] == entity.ErrorJobQuotaExceeded && small[1] == "") {
+		t.Fatalf("expected one file within the job quota, got %v", small)
+	}
+}
+
+func TestDownloadUseCase_PauseAndResumeJob(t *testing.T) {
+	payload := strings.Repeat("abcdefghij", 50_000)
+	modTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var (
+		requests atomic.Int32
+		resumed  atomic.Value
+	)
+	stalled := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if requests.Add(1) == 1 {
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+			_, _ = io.WriteString(w, payload[:len(payload)/2])
+			w.(http.Flusher).Flush()
+			close(stalled)
+			<-r.Context().Done()
+			return
+		}
+		resumed.Store(r.Header.Get("Range"))
+		http.ServeContent(w, r, "a.txt", modTime, strings.NewReader(payload))
+	}))
+	defer srv.Close()
+
+	u := newUseCase()
+
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.URL+"/a.txt"), entity.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	<-stalled
+
+	paused, err := u.PauseJob(context.Background(), created.ID)
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	if paused.Status != entity.Paused || len(paused.Items) != 1 || paused.Items[0].State != entity.ItemPending {
+		t.Fatalf("expected Paused job with a pending item, got %v %+v", paused.Status, paused.Items)
+	}
+	if paused.Items[0].BytesReceived == 0 || paused.Items[0].BytesTotal != int64(len(payload)) {
+		t.Fatalf("expected the checkpoint in the item progress, got %+v", paused.Items[0])
+	}
+
+	if _, err := u.ResumeJob(context.Background(), created.ID); err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	job := waitJob(t, u, created.ID)
+	if job.Status != entity.Done || len(job.Items) != 1 || job.Items[0].FileID == "" {
+		t.Fatalf("expected Done job with one stored item, got %v %+v", job.Status, job.Items)
+	}
+	if rng, _ := resumed.Load().(string); rng == "" || rng == "bytes=0-" {
+		t.Fatalf("expected resume from the checkpoint, got range %q", rng)
+	}
+
+	content, _, err := u.GetFile(context.Background(), job.ID, job.Items[0].FileID)
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	defer content.Close()
+
+	data, _ := io.ReadAll(content)
+	if string(data) != payload {
+		t.Fatalf("resumed content does not match upstream payload")
+	}
+}
+
+func TestDownloadUseCase_StartJob_LimitsConnectionsPerHost(t *testing.T) {
+	var active, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	u := newUseCase(usecases.WithHostLimiter(hostlimiter.New(
+		hostlimiter.WithHostLimit(hostlimiter.Limit{Pattern: "127.0.0.1", MaxConns: 2}),
+	)))
+
+	urls := make([]string, 6)
+	for i := range urls {
+		urls[i] = srv.URL + "/" + strconv.Itoa(i)
+	}
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(urls...), entity.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	job := waitJob(t, u, created.ID)
+	if len(job.Items) != len(urls) {
+		t.Fatalf("expected %d items, got %d", len(urls), len(job.Items))
+	}
+	if peak.Load() > 2 {
+		t.Fatalf("expected at most 2 concurrent connections, got %d", peak.Load())
+	}
+}
+
+func TestDownloadUseCase_StartJob_ThrottlesJobBandwidth(t *testing.T) {
+	payload := strings.Repeat("x", 200<<10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, payload)
+	}))
+	defer srv.Close()
+
+	u := newUseCase()
+
+	start := time.Now()
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.URL), entity.DownloadOptions{MaxBandwidth: 100 << 10})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	running, err := u.GetJob(context.Background(), created.ID)
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	if running.Status != entity.Process || running.Throughput <= 0 {
+		t.Fatalf("expected running job to report throughput, got %v %d", running.Status, running.Throughput)
+	}
+
+	job := waitJob(t, u, created.ID)
+	if len(job.Items) != 1 || job.Items[0].FileID == "" {
+		t.Fatalf("expected one stored item, got %+v", job.Items)
+	}
+	// the first 100kb fit in the burst, the rest takes about a second
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Fatalf("expected the download to be throttled, took %v", elapsed)
+	}
+}
+
+func TestDownloadUseCase_StartJob_FailsStalledTransfer(t *testing.T) {
+	payload := strings.Repeat("x", 200<<10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stall" {
+			w.Header().Set("Content-Length", "1024")
+			_, _ = io.WriteString(w, "partial")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		_, _ = io.WriteString(w, payload)
+	}))
+	defer srv.Close()
+
+	u := newUseCase(usecases.WithStallTimeout(200 * time.Millisecond))
+	options := entity.DownloadOptions{
+		RetryPolicy: &entity.RetryPolicy{MaxAttempts: 1},
+		// waiting on the bandwidth limit takes longer than the stall timeout
+		MaxBandwidth: 100 << 10,
+	}
+
+	start := time.Now()
+	created, err := u.StartJob(context.Background(), 10*time.Second, sources(srv.URL+"/stall", srv.URL+"/slow"), options)
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	job := waitJob(t, u, created.ID)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the stalled transfer to be cut short, took %v", elapsed)
+	}
+	if len(job.Items) != 2 {
+		t.Fatalf("expected two items, got %+v", job.Items)
+	}
+	if stalled := job.Items[0]; stalled.State != entity.ItemFailed || stalled.Error == nil || stalled.Error.Code != entity.ErrorTimeout {
+		t.Fatalf("expected the stalled item to time out, got %+v", stalled)
+	}
+	if slow := job.Items[1]; slow.State != entity.ItemDone || slow.BytesReceived != int64(len(payload)) {
+		t.Fatalf("expected the throttled item to complete, got %+v", slow)
+	}
+}
+
+func TestDownloadUseCase_BlocksInternalDestinations(t *testing.T) {
+	var internalHits atomic.Int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		internalHits.Add(1)
+		_, _ = io.WriteString(w, "secret")
+	}))
+	defer internal.Close()
+
+	// the public server stands in for an outside host and redirects inward
+	public := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://localhost:"+strconv.Itoa(internal.Listener.Addr().(*net.TCPAddr).Port)+"/", http.StatusFound)
+			return
+		}
+		_, _ = io.WriteString(w, "public")
+	}))
+	defer public.Close()
+
+	u := usecases.NewDownloadUseCase(usecases.WithNetGuard(netguard.New(
+		netguard.WithAllowHosts("127.0.0.1"),
+		netguard.WithDenyHosts("localhost"),
+	)))
+
+	urls := []string{
+		public.URL + "/",
+		public.URL + "/redirect",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]:1/",
+	}
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(urls...), entity.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	job := waitJob(t, u, created.ID)
+	if job.Items[0].State != entity.ItemDone {
+		t.Fatalf("expected the allowed host to be downloaded, got %+v", job.Items[0])
+	}
+	for _, item := range job.Items[1:] {
+		if item.Error == nil || item.Error.Code != entity.ErrorBlockedDestination {
+			t.Fatalf("expected %s for %s, got %+v", entity.ErrorBlockedDestination, item.URL, item.Error)
+		}
+		if len(item.Attempts) != 1 {
+			t.Fatalf("expected blocked %s not to be retried, got %d attempts", item.URL, len(item.Attempts))
+		}
+	}
+	if internalHits.Load() != 0 {
+		t.Fatalf("expected the internal server not to be reached, got %d requests", internalHits.Load())
+	}
+}
+
+func TestDownloadUseCase_RecordsRedirects(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/file", http.StatusMovedPermanently)
+		case "/elsewhere":
+			port := srv.Listener.Addr().(*net.TCPAddr).Port
+			http.Redirect(w, r, "http://localhost:"+strconv.Itoa(port)+"/file", http.StatusFound)
+		default:
+			_, _ = io.WriteString(w, "content")
+		}
+	}))
+	defer srv.Close()
+
+	start := func(u *usecases.DownloadUseCase, url string, policy *entity.RedirectPolicy) entity.DownloadItem {
+		t.Helper()
+		created, err := u.StartJob(context.Background(), 5*time.Second, sources(url), entity.DownloadOptions{RedirectPolicy: policy})
+		if err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		return waitJob(t, u, created.ID).Items[0]
+	}
+
+	u := newUseCase()
+
+	item := start(u, srv.URL+"/a", nil)
+	if item.State != entity.ItemDone {
+		t.Fatalf("expected item done, got %+v", item)
+	}
+	want := []entity.Redirect{
+		{URL: srv.URL + "/b", StatusCode: http.StatusFound},
+		{URL: srv.URL + "/file", StatusCode: http.StatusMovedPermanently},
+	}
+	if len(item.Redirects) != len(want) || item.Redirects[0] != want[0] || item.Redirects[1] != want[1] {
+		t.Fatalf("expected redirects %v, got %v", want, item.Redirects)
+	}
+	if item.FinalURL != srv.URL+"/file" {
+		t.Fatalf("expected final url %s, got %s", srv.URL+"/file", item.FinalURL)
+	}
+
+	item = start(u, srv.URL+"/a", &entity.RedirectPolicy{MaxRedirects: 1, AllowCrossHost: true})
+	if item.Error == nil || item.Error.Code != entity.ErrorRedirectNotAllowed {
+		t.Fatalf("expected %s past max redirects, got %+v", entity.ErrorRedirectNotAllowed, item.Error)
+	}
+
+	item = start(u, srv.URL+"/elsewhere", &entity.RedirectPolicy{MaxRedirects: 10})
+	if item.Error == nil || item.Error.Code != entity.ErrorRedirectNotAllowed {
+		t.Fatalf("expected %s for a cross host redirect, got %+v", entity.ErrorRedirectNotAllowed, item.Error)
+	}
+	if len(item.Redirects) != 1 || !strings.Contains(item.Redirects[0].URL, "localhost") {
+		t.Fatalf("expected the refused hop to be recorded, got %v", item.Redirects)
+	}
+
+	item = start(u, srv.URL+"/elsewhere", nil)
+	if item.State != entity.ItemDone || !strings.HasPrefix(item.FinalURL, "http://localhost:") {
+		t.Fatalf("expected cross host redirects to be followed by default, got %+v", item)
+	}
+}
+
+func TestDownloadUseCase_SendsRequestSettings(t *testing.T) {
+	type seen struct {
+		userAgent, team, authorization string
+	}
+	var (
+		mu       sync.Mutex
+		requests = map[string][]seen{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path] = append(requests[r.URL.Path], seen{r.UserAgent(), r.Header.Get("X-Team"), r.Header.Get("Authorization")})
+		mu.Unlock()
+
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	u := newUseCase(usecases.WithUserAgent("test-agent/1.0"), usecases.WithHTTPCache(8))
+
+	srcs := sources(srv.URL+"/own", srv.URL+"/inherited")
+	srcs[0].Request = entity.RequestSettings{
+		Headers:   map[string]entity.Secret{"X-Team": "files", "User-Agent": "custom/2.0"},
+		BasicAuth: &entity.BasicAuth{Username: "alice", Password: "hunter2"},
+	}
+	options := entity.DownloadOptions{
+		Request: entity.RequestSettings{
+			Headers:     map[string]entity.Secret{"X-Team": "jobs"},
+			BearerToken: "job-token",
+		},
+	}
+
+	for range 2 {
+		created, err := u.StartJob(context.Background(), 5*time.Second, srcs, options)
+		if err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		job := waitJob(t, u, created.ID)
+		if job.Status != entity.Done {
+			t.Fatalf("expected job done, got %s", job.Status.String())
+		}
+
+		dump := fmt.Sprintf("%v %+v %#v", job, job, job)
+		encoded, _ := json.Marshal(job)
+		for _, secret := range []string{"hunter2", "job-token", "files", "jobs"} {
+			if strings.Contains(dump, secret) || strings.Contains(string(encoded), secret) {
+				t.Fatalf("expected %q to be redacted", secret)
+			}
+		}
+	}
+
+	want := map[string]seen{
+		"/own":       {"custom/2.0", "files", "Basic YWxpY2U6aHVudGVyMg=="},
+		"/inherited": {"test-agent/1.0", "jobs", "Bearer job-token"},
+	}
+	for path, w := range want {
+		// responses to credentialed requests are never reused from the cache
+		if got := requests[path]; len(got) != 2 || got[0] != w || got[1] != w {
+			t.Fatalf("%s: expected two requests with %+v, got %+v", path, w, got)
+		}
+	}
+}
+
+func TestDownloadUseCase_DropsHeadersOnCrossHostRedirect(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		keys = map[string]string{}
+	)
+	record := func(r *http.Request) {
+		mu.Lock()
+		keys[r.Host+r.URL.Path] = r.Header.Get("X-Api-Key")
+		mu.Unlock()
+	}
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		_, _ = io.WriteString(w, "elsewhere")
+	}))
+	defer other.Close()
+	_, otherPort, _ := net.SplitHostPort(other.Listener.Addr().String())
+	elsewhere := "localhost:" + otherPort
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/file", http.StatusFound)
+		case "/cross":
+			http.Redirect(w, r, "http://"+elsewhere+"/file", http.StatusFound)
+		default:
+			_, _ = io.WriteString(w, "file")
+		}
+	}))
+	defer srv.Close()
+	origin := srv.Listener.Addr().String()
+
+	u := newUseCase()
+
+	srcs := sources(srv.URL+"/same", srv.URL+"/cross")
+	options := entity.DownloadOptions{
+		Request: entity.RequestSettings{Headers: map[string]entity.Secret{"X-Api-Key": "topsecret"}},
+	}
+	created, err := u.StartJob(context.Background(), 5*time.Second, srcs, options)
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	job := waitJob(t, u, created.ID)
+	for _, item := range job.Items {
+		if item.State != entity.ItemDone {
+			t.Fatalf("expected item done, got %+v", item)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]string{
+		origin + "/same":    "topsecret",
+		origin + "/file":    "topsecret",
+		origin + "/cross":   "topsecret",
+		elsewhere + "/file": "",
+	}
+	if !maps.Equal(keys, want) {
+		t.Fatalf("expected the key to stay on the source host, got %v", keys)
+	}
+}
+
+func TestDownloadUseCase_RoutesThroughProxies(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "direct")
+	}))
+	defer origin.Close()
+
+	// the proxy answers for every host it is asked to forward to
+	var forwarded atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Store(r.URL.String())
+		_, _ = io.WriteString(w, "proxied")
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	u := newUseCase(usecases.WithProxies(egress.New(
+		egress.WithDefaultProxy(proxyURL),
+		egress.WithNoProxy("localhost"),
+	)))
+
+	originURL := strings.Replace(origin.URL, "127.0.0.1", "localhost", 1)
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources("http://files.partner.example/a.txt", originURL), entity.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	job := waitJob(t, u, created.ID)
+
+	if item := job.Items[0]; item.State != entity.ItemDone || item.Proxy != proxy.URL {
+		t.Fatalf("expected item downloaded through %s, got %+v", proxy.URL, item)
+	}
+	if got := forwarded.Load(); got != "http://files.partner.example/a.txt" {
+		t.Fatalf("expected the proxy to forward the file url, got %v", got)
+	}
+	if item := job.Items[1]; item.State != entity.ItemDone || item.Proxy != "" {
+		t.Fatalf("expected excluded host to connect directly, got %+v", item)
+	}
+
+	// a proxy named by a job is a destination like any other
+	blocked := usecases.NewDownloadUseCase()
+	created, err = blocked.StartJob(context.Background(), 5*time.Second, sources("http://files.partner.example/a.txt"), entity.DownloadOptions{
+		Proxy: entity.Secret(proxy.URL),
+	})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	job = waitJob(t, blocked, created.ID)
+	if item := job.Items[0]; item.Error == nil || item.Error.Code != entity.ErrorBlockedDestination {
+		t.Fatalf("expected %s for an internal job proxy, got %+v", entity.ErrorBlockedDestination, item.Error)
+	}
+}
+
+// ftpServer is a minimal passive mode FTP server for the files it holds.
+type ftpServer struct {
+	ln       net.Listener
+	files    map[string]string
+	user     string
+	password string
+	mu       sync.Mutex
+	// drops makes as many RETR commands of a file cut the data connection halfway.
+	drops map[string]int
+	// rests records the offsets of REST commands.
+	rests []int64
+	// commands records every command received.
+	commands []string
+}
+
+func newFTPServer(t *testing.T, files map[string]string) *ftpServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	s := &ftpServer{ln: ln, files: files, user: "alice", password: "hunter2", drops: map[string]int{}}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *ftpServer) url(path string) string {
+	return "ftp://" + s.user + ":" + s.password + "@" + s.ln.Addr().String() + path
+}
+
+func (s *ftpServer) serve(conn net.Conn) {
+	c := textproto.NewConn(conn)
+	defer c.Close()
+
+	var (
+		user, password string
+		data           net.Listener
+		rest           int64
+	)
+	defer func() {
+		if data != nil {
+			_ = data.Close()
+		}
+	}()
+
+	_ = c.PrintfLine("220 ready")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+		cmd, arg, _ := strings.Cut(line, " ")
+		content, exists := s.files[arg]
+
+		switch strings.ToUpper(cmd) {
+		case "USER":
+			user = arg
+			_ = c.PrintfLine("331 password required")
+		case "PASS":
+			password = arg
+			if user != s.user || password != s.password {
+				_ = c.PrintfLine("530 not logged in")
+				continue
+			}
+			_ = c.PrintfLine("230 logged in")
+		case "TYPE":
+			_ = c.PrintfLine("200 ok")
+		case "SIZE":
+			if !exists {
+				_ = c.PrintfLine("550 not found")
+				continue
+			}
+			_ = c.PrintfLine("213 %d", len(content))
+		case "MDTM":
+			_ = c.PrintfLine("213 20240101000000")
+		case "EPSV":
+			if data, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+				_ = c.PrintfLine("425 cannot open data connection")
+				continue
+			}
+			_ = c.PrintfLine("229 Entering Extended Passive Mode (|||%d|)", data.Addr().(*net.TCPAddr).Port)
+		case "REST":
+			rest, _ = strconv.ParseInt(arg, 10, 64)
+			s.mu.Lock()
+			s.rests = append(s.rests, rest)
+			s.mu.Unlock()
+			_ = c.PrintfLine("350 restarting at %d", rest)
+		case "RETR":
+			if !exists {
+				_ = c.PrintfLine("550 not found")
+				continue
+			}
+			_ = c.PrintfLine("150 opening data connection")
+			dc, err := data.Accept()
+			if err != nil {
+				return
+			}
+			content = content[rest:]
+			s.mu.Lock()
+			drop := s.drops[arg] > 0
+			s.drops[arg]--
+			s.mu.Unlock()
+			if drop {
+				_, _ = io.WriteString(dc, content[:len(content)/2])
+				_ = dc.Close()
+				_ = c.PrintfLine("426 connection closed, transfer aborted")
+				continue
+			}
+			_, _ = io.WriteString(dc, content)
+			_ = dc.Close()
+			_ = c.PrintfLine("226 transfer complete")
+		case "QUIT":
+			_ = c.PrintfLine("221 bye")
+			return
+		default:
+			_ = c.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestDownloadUseCase_FetchesFTP(t *testing.T) {
+	content := strings.Repeat("ftp content ", 100)
+	srv := newFTPServer(t, map[string]string{"pub/a.txt": content, "pub/big.bin": strings.Repeat("b", 5000)})
+	srv.drops["pub/a.txt"] = 1
+
+	received := make(chan string, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer hook.Close()
+
+	u := newUseCase(usecases.WithRetryPolicy(entity.RetryPolicy{
+		MaxAttempts:     3,
+		BaseBackoff:     time.Millisecond,
+		MaxBackoff:      time.Millisecond,
+		RetryableErrors: []entity.DownloadItemErrorCode{entity.ErrorNetwork},
+	}))
+
+	badLogin := strings.Replace(srv.url("/pub/a.txt"), "hunter2", "wrong", 1)
+	// encoded line breaks would end a command and send the rest as another
+	injectPath := srv.url("/pub/a.txt%0D%0ADELE%20pub/a.txt")
+	injectPassword := strings.Replace(srv.url("/pub/a.txt"), "hunter2", "hunter2%0D%0ADELE%20pub%2Fa.txt", 1)
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.url("/pub/a.txt"), srv.url("/pub/missing.txt"), srv.url("/pub/big.bin"), badLogin, injectPath, injectPassword), entity.DownloadOptions{
+		MaxFileSize: 2000,
+		Callback:    &entity.Callback{URL: hook.URL},
+	})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	job := waitJob(t, u, created.ID)
+
+	// the first transfer is cut halfway and resumed from where it stopped
+	item := job.Items[0]
+	if item.State != entity.ItemDone || len(item.Attempts) != 2 {
+		t.Fatalf("expected item done after 2 attempts, got %+v", item)
+	}
+	sum := sha256.Sum256([]byte(content))
+	if item.SHA256 != hex.EncodeToString(sum[:]) || item.BytesTotal != int64(len(content)) {
+		t.Fatalf("expected the whole file, got sha256 %s of %d bytes", item.SHA256, item.BytesTotal)
+	}
+	if strings.Contains(item.FinalURL, "hunter2") {
+		t.Fatalf("expected credentials to be redacted from %s", item.FinalURL)
+	}
+	srv.mu.Lock()
+	rests := srv.rests
+	srv.mu.Unlock()
+	if !slices.Contains(rests, int64(len(content)/2)) {
+		t.Fatalf("expected a resume from %d, got offsets %v", len(content)/2, rests)
+	}
+
+	want := []struct {
+		code   entity.DownloadItemErrorCode
+		status int
+	}{
+		{entity.ErrorHTTP, 550},
+		{entity.ErrorTooLarge, 150},
+		{entity.ErrorHTTP, 530},
+	}
+	for i, w := range want {
+		item := job.Items[i+1]
+		if item.Error == nil || item.Error.Code != w.code || item.Attempts[0].StatusCode != w.status {
+			t.Fatalf("item %d: expected %s with status %d, got %+v", i+1, w.code, w.status, item)
+		}
+	}
+
+	for _, item := range job.Items[4:] {
+		if item.State != entity.ItemFailed || item.Error == nil || item.Error.Code != entity.ErrorInvalidURL {
+			t.Fatalf("expected %s for %s, got %+v", entity.ErrorInvalidURL, item.URL, item)
+		}
+	}
+	srv.mu.Lock()
+	commands := srv.commands
+	srv.mu.Unlock()
+	for _, command := range commands {
+		if strings.HasPrefix(command, "DELE") {
+			t.Fatalf("expected no injected command, got %q", command)
+		}
+	}
+
+	// the password of the source URLs is left out wherever they are shown
+	rec := httptest.NewRecorder()
+	router.NewRouter(handlers.NewHTTPHandlers(u)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/downloads/"+created.ID, nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), srv.ln.Addr().String()) {
+		t.Fatalf("expected the job, got %d %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "hunter2") {
+		t.Fatalf("expected no password in the job, got %s", rec.Body)
+	}
+
+	select {
+	case payload := <-received:
+		if strings.Contains(payload, "hunter2") {
+			t.Fatalf("expected no password in the webhook, got %s", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("webhook not delivered")
+	}
+
+	var buf bytes.Buffer
+	if err := u.WriteArchive(context.Background(), job, usecases.ArchiveTarGz, &buf); err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("expected a gzip stream, got %v", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("expected a manifest, got %v", err)
+		}
+		if hdr.Name != "manifest.json" {
+			continue
+		}
+		manifest, _ := io.ReadAll(tr)
+		if !strings.Contains(string(manifest), srv.ln.Addr().String()) || strings.Contains(string(manifest), "hunter2") {
+			t.Fatalf("expected the sources without password in the manifest, got %s", manifest)
+		}
+		break
+	}
+}
+
+// socks5Server is a minimal SOCKS5 proxy without authentication that records
+// the destinations it connects to.
+type socks5Server struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	connects []string
+}
+
+func newSOCKS5Server(t *testing.T) *socks5Server {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	s := &socks5Server{ln: ln}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socks5Server) url() string {
+	return "socks5://" + s.ln.Addr().String()
+}
+
+func (s *socks5Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// greeting: version, methods; request: version, command, reserved, address
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, head[1])); err != nil {
+		return
+	}
+	_, _ = conn.Write([]byte{5, 0})
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		_, _ = io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 3:
+		n := make([]byte, 1)
+		_, _ = io.ReadFull(conn, n)
+		name := make([]byte, n[0])
+		_, _ = io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1])))
+
+	target, err := net.Dial("tcp", address)
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	s.mu.Lock()
+	s.connects = append(s.connects, address)
+	s.mu.Unlock()
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go func() {
+		_, _ = io.Copy(target, conn)
+		_ = target.Close()
+	}()
+	_, _ = io.Copy(conn, target)
+}
+
+func TestDownloadUseCase_FetchesFTPThroughProxies(t *testing.T) {
+	srv := newFTPServer(t, map[string]string{"pub/a.txt": "through the proxy"})
+	socks := newSOCKS5Server(t)
+	socksURL, _ := url.Parse(socks.url())
+	httpProxy, _ := url.Parse("http://proxy.example:3128")
+
+	start := func(u *usecases.DownloadUseCase, options entity.DownloadOptions) entity.DownloadItem {
+		t.Helper()
+		created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.url("/pub/a.txt")), options)
+		if err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		return waitJob(t, u, created.ID).Items[0]
+	}
+
+	// the control and the data connection both go through the proxy
+	routed := newUseCase(usecases.WithProxies(egress.New(egress.WithDefaultProxy(socksURL))))
+	if item := start(routed, entity.DownloadOptions{}); item.State != entity.ItemDone || item.Proxy != socks.url() {
+		t.Fatalf("expected item downloaded through %s, got %+v", socks.url(), item)
+	}
+	socks.mu.Lock()
+	connects := len(socks.connects)
+	socks.mu.Unlock()
+	if connects != 2 {
+		t.Fatalf("expected a control and a data connection through the proxy, got %d", connects)
+	}
+
+	direct := newUseCase()
+	if item := start(direct, entity.DownloadOptions{Proxy: entity.Secret(socks.url())}); item.State != entity.ItemDone || item.Proxy != socks.url() {
+		t.Fatalf("expected item downloaded through the job proxy, got %+v", item)
+	}
+
+	// an HTTP proxy cannot carry FTP, which must not go out directly instead
+	srv.mu.Lock()
+	commands := len(srv.commands)
+	srv.mu.Unlock()
+	unsupported := newUseCase(usecases.WithProxies(egress.New(egress.WithDefaultProxy(httpProxy))))
+	if item := start(unsupported, entity.DownloadOptions{}); item.Error == nil || item.Error.Code != entity.ErrorProxyUnsupported {
+		t.Fatalf("expected %s, got %+v", entity.ErrorProxyUnsupported, item)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.commands) != commands {
+		t.Fatalf("expected no connection to the server, got %v", srv.commands[commands:])
+	}
+}
+
+func TestDownloadUseCase_WriteArchive(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, "content of "+r.URL.Path)
+	}))
+	defer srv.Close()
+
+	u := newUseCase()
+
+	created, err := u.StartJob(context.Background(), 5*time.Second,
+		sources(srv.URL+"/a/file.txt", srv.URL+"/b/file.txt", srv.URL+"/manifest.json", srv.URL+"/missing"), entity.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	job := waitJob(t, u, created.ID)
+
+	want := map[string]string{
+		"file.txt":        "content of /a/file.txt",
+		"file-1.txt":      "content of /b/file.txt",
+		"manifest-1.json": "content of /manifest.json",
+	}
+
+	readManifest := func(t *testing.T, entries map[string]string) {
+		t.Helper()
+
+		var m struct {
+			JobID string `json:"job_id"`
+			Files []struct {
+				Name   string `json:"name"`
+				URL    string `json:"url"`
+				SHA256 string `json:"sha256"`
+			} `json:"files"`
+			Failed []struct {
+				URL   string `json:"url"`
+				Error string `json:"error"`
+			} `json:"failed"`
+		}
+		if err := json.Unmarshal([]byte(entries["manifest.json"]), &m); err != nil {
+			t.Fatalf("expected a manifest, got %v", err)
+		}
+		if m.JobID != job.ID || len(m.Files) != 3 || len(m.Failed) != 1 {
+			t.Fatalf("unexpected manifest %+v", m)
+		}
+		for _, f := range m.Files {
+			sum := sha256.Sum256([]byte(entries[f.Name]))
+			if f.SHA256 != hex.EncodeToString(sum[:]) {
+				t.Fatalf("unexpected digest of %s: %s", f.Name, f.SHA256)
+			}
+		}
+		if m.Failed[0].URL != srv.URL+"/missing" || m.Failed[0].Error != string(entity.ErrorHTTP) {
+			t.Fatalf("unexpected failed items %+v", m.Failed)
+		}
+
+		delete(entries, "manifest.json")
+		if !maps.Equal(entries, want) {
+			t.Fatalf("unexpected entries %v", entries)
+		}
+	}
+
+	t.Run("zip", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := u.WriteArchive(context.Background(), job, usecases.ArchiveZip, &buf); err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("expected a zip archive, got %v", err)
+		}
+		entries := make(map[string]string)
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("expected nil err, got %v", err)
+			}
+			data, _ := io.ReadAll(rc)
+			_ = rc.Close()
+			entries[f.Name] = string(data)
+		}
+		readManifest(t, entries)
+	})
+
+	t.Run("tar.gz", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := u.WriteArchive(context.Background(), job, usecases.ArchiveTarGz, &buf); err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+
+		gz, err := gzip.NewReader(&buf)
+		if err != nil {
+			t.Fatalf("expected a gzip stream, got %v", err)
+		}
+		tr := tar.NewReader(gz)
+		entries := make(map[string]string)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("expected nil err, got %v", err)
+			}
+			data, _ := io.ReadAll(tr)
+			entries[hdr.Name] = string(data)
+		}
+		readManifest(t, entries)
+	})
+
+	if err := u.WriteArchive(context.Background(), job, "rar", io.Discard); !errors.Is(err, usecases.ErrUnknownArchiveFormat) {
+		t.Fatalf("expected ErrUnknownArchiveFormat, got %v", err)
+	}
+}
+
+func TestDownloadUseCase_ExtractsArchives(t *testing.T) {
+	zipOf := func(entries ...string) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for i := 0; i < len(entries); i += 2 {
+			w, _ := zw.Create(entries[i])
+			_, _ = io.WriteString(w, entries[i+1])
+		}
+		_ = zw.Close()
+		return buf.Bytes()
+	}
+	tarGzOf := func(entries ...string) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for i := 0; i < len(entries); i += 2 {
+			_ = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: entries[i], Size: int64(len(entries[i+1])), Mode: 0o644})
+			_, _ = io.WriteString(tw, entries[i+1])
+		}
+		_ = tw.Close()
+		_ = gz.Close()
+		return buf.Bytes()
+	}
+
+	files := map[string][]byte{
+		"/bundle.zip":    zipOf("docs/", "", "docs/a.txt", "alpha", "b.txt", "beta"),
+		"/bundle.tar.gz": tarGzOf("c.txt", "gamma", "./nested/d.txt", "delta"),
+		"/slip.zip":      zipOf("ok.txt", "fine", "../../etc/evil", "boom"),
+		"/many.zip":      zipOf("1", "", "2", "", "3", "", "4", ""),
+		"/bomb.tar.gz":   tarGzOf("big", strings.Repeat("0", 4096)),
+		"/plain.txt":     []byte("not an archive"),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(files[r.URL.Path])
+	}))
+	defer srv.Close()
+
+	u := newUseCase(usecases.WithExtractLimits(3, 4096))
+
+	paths := []string{"/bundle.zip", "/bundle.tar.gz", "/slip.zip", "/many.zip", "/bomb.tar.gz", "/plain.txt"}
+	srcs := make([]entity.DownloadSource, len(paths))
+	for i, p := range paths {
+		srcs[i] = entity.DownloadSource{URL: srv.URL + p, Extract: true}
+	}
+	created, err := u.StartJob(context.Background(), 5*time.Second, srcs, entity.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	job := waitJob(t, u, created.ID)
+
+	wantChildren := [][]string{
+		{"docs/a.txt", "alpha", "b.txt", "beta"},
+		{"c.txt", "gamma", "nested/d.txt", "delta"},
+	}
+	for i, want := range wantChildren {
+		item := job.Items[i]
+		if item.State != entity.ItemDone || item.FileID == "" || len(item.Children) != len(want)/2 {
+			t.Fatalf("unexpected item %s: %+v", paths[i], item)
+		}
+		for j, child := range item.Children {
+			content, _, err := u.FileRepository.Open(context.Background(), child.FileID)
+			if err != nil {
+				t.Fatalf("expected nil err, got %v", err)
+			}
+			data, _ := io.ReadAll(content)
+			_ = content.Close()
+			if child.Path != want[2*j] || string(data) != want[2*j+1] || child.Size != int64(len(data)) {
+				t.Fatalf("unexpected child %+v with content %q", child, data)
+			}
+		}
+	}
+
+	wantErrors := []entity.DownloadItemErrorCode{
+		entity.ErrorUnsafeArchive,
+		entity.ErrorArchiveTooLarge,
+		entity.ErrorArchiveTooLarge,
+		entity.ErrorExtractFailed,
+	}
+	for i, code := range wantErrors {
+		item := job.Items[len(wantChildren)+i]
+		if item.State != entity.ItemFailed || item.Error == nil || item.Error.Code != code {
+			t.Fatalf("expected %s for %s, got %+v", code, item.URL, item)
+		}
+		if item.FileID != "" || len(item.Children) != 0 {
+			t.Fatalf("expected nothing kept of %s, got %+v", item.URL, item)
+		}
+	}
+}
+
+func TestDownloadUseCase_DetectsTypesAndFilenames(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	pdf := "%PDF-1.7\n%\xe2\xe3\xcf\xd3\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/img":
+			// keep the server from declaring a sniffed type itself
+			w.Header()["Content-Type"] = nil
+			_, _ = io.WriteString(w, png)
+		case "/report.bin":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", `attachment; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`)
+			_, _ = io.WriteString(w, pdf)
+		case "/page":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="../../etc/passwd"`)
+			_, _ = io.WriteString(w, "a,b\n1,2\n")
+		}
+	}))
+	defer srv.Close()
+
+	u := newUseCase()
+
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.URL+"/img", srv.URL+"/report.bin", srv.URL+"/page"), entity.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	job := waitJob(t, u, created.ID)
+
+	want := []entity.FileMetadata{
+		{MimeType: "image/png", DetectedMimeType: "image/png", Filename: "img.png"},
+		{MimeType: "application/pdf", DeclaredMimeType: "application/octet-stream", DetectedMimeType: "application/pdf", Filename: "résumé.pdf"},
+		{MimeType: "text/csv", DeclaredMimeType: "text/csv", DetectedMimeType: "text/plain; charset=utf-8", Filename: "passwd"},
+	}
+	for i, w := range want {
+		metadata, err := u.FileRepository.Metadata(context.Background(), job.Items[i].FileID)
+		if err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		if metadata.MimeType != w.MimeType || metadata.DeclaredMimeType != w.DeclaredMimeType ||
+			metadata.DetectedMimeType != w.DetectedMimeType || metadata.Filename != w.Filename {
+			t.Fatalf("unexpected metadata of %s: %+v", job.Items[i].URL, metadata)
+		}
+	}
+
+	// a generic declared type does not let a denied one through
+	created, err = u.StartJob(context.Background(), 5*time.Second, sources(srv.URL+"/report.bin"), entity.DownloadOptions{
+		DeniedMimeTypes: []string{"application/pdf"},
+		BypassCache:     true,
+	})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	job = waitJob(t, u, created.ID)
+	if item := job.Items[0]; item.Error == nil || item.Error.Code != entity.ErrorMimeNotAllowed {
+		t.Fatalf("expected MIME_NOT_ALLOWED, got %+v", item)
+	}
+
+	// a missing or generic declared type leaves the allowlist to the sniffed one
+	created, err = u.StartJob(context.Background(), 5*time.Second, sources(srv.URL+"/img", srv.URL+"/report.bin", srv.URL+"/page"), entity.DownloadOptions{
+		AllowedMimeTypes: []string{"image/*"},
+		BypassCache:      true,
+	})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	job = waitJob(t, u, created.ID)
+	if item := job.Items[0]; item.State != entity.ItemDone {
+		t.Fatalf("expected the sniffed png to be allowed, got %+v", item)
+	}
+	for _, item := range job.Items[1:] {
+		if item.Error == nil || item.Error.Code != entity.ErrorMimeNotAllowed {
+			t.Fatalf("expected MIME_NOT_ALLOWED for %s, got %+v", item.URL, item)
+		}
+	}
+}
+
+func TestDownloadUseCase_RecordsProvenance(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new.txt", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v7"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Header().Add("Cache-Control", "public")
+		w.Header().Add("Cache-Control", "max-age=0")
+		w.Header().Set("X-Internal", "dropped")
+		_, _ = io.WriteString(w, "provenance")
+	}))
+	defer srv.Close()
+
+	u := newUseCase()
+
+	source, _ := url.Parse(srv.URL + "/old")
+	source.User = url.UserPassword("user", "hunter2")
+
+	before := time.Now()
+	created, err := u.StartJob(context.Background(), 5*time.Second, sources(source.String()), entity.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	job := waitJob(t, u, created.ID)
+
+	metadata, err := u.GetFileMetadata(context.Background(), job.ID, job.Items[0].FileID)
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	if metadata.SourceURL != srv.URL+"/old" {
+		t.Fatalf("unexpected source URL %q", metadata.SourceURL)
+	}
+	if metadata.FinalURL != srv.URL+"/new.txt" {
+		t.Fatalf("unexpected final URL %q", metadata.FinalURL)
+	}
+	if metadata.Size != int64(len("provenance")) || metadata.ETag != `"v7"` || metadata.LastModified != "Mon, 02 Jan 2006 15:04:05 GMT" {
+		t.Fatalf("unexpected metadata %+v", metadata)
+	}
+	wantHeaders := map[string]string{
+		"Content-Type":  "text/plain",
+		"Etag":          `"v7"`,
+		"Last-Modified": "Mon, 02 Jan 2006 15:04:05 GMT",
+		"Cache-Control": "public, max-age=0",
+		"Date":          metadata.Headers["Date"],
+	}
+	if !maps.Equal(metadata.Headers, wantHeaders) {
+		t.Fatalf("unexpected headers %v", metadata.Headers)
+	}
+	if metadata.StartedAt.Before(before) || metadata.FinishedAt.Before(metadata.StartedAt) || metadata.FinishedAt.After(time.Now()) {
+		t.Fatalf("unexpected timestamps %v - %v", metadata.StartedAt, metadata.FinishedAt)
+	}
+}
+
+func TestDownloadUseCase_CheckpointLifetime(t *testing.T) {
+	payload := strings.Repeat("abcdefghij", 50_000)
+	modTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// newServer cuts the first response in half, stalling it until the client
+	// goes away when stall is set, and records the range of the next one.
+	newServer := func(stall bool) (*httptest.Server, chan struct{}, *atomic.Value) {
+		var requests atomic.Int32
+		var resumed atomic.Value
+		stalled := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v1"`)
+			if requests.Add(1) == 1 {
+				w.Header().Set("Accept-Ranges", "bytes")
+				w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+				_, _ = io.WriteString(w, payload[:len(payload)/2])
+				w.(http.Flusher).Flush()
+				close(stalled)
+				if stall {
+					<-r.Context().Done()
+					return
+				}
+				panic(http.ErrAbortHandler)
+			}
+			resumed.Store(r.Header.Get("Range"))
+			http.ServeContent(w, r, "a.txt", modTime, strings.NewReader(payload))
+		}))
+		return srv, stalled, &resumed
+	}
+
+	resumeFromScratch := func(t *testing.T, u *usecases.DownloadUseCase, jobID string, resumed *atomic.Value) {
+		t.Helper()
+
+		if _, err := u.ResumeJob(context.Background(), jobID); err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		job := waitJob(t, u, jobID)
+		if job.Status != entity.Done || job.Items[0].State != entity.ItemDone {
+			t.Fatalf("expected Done job with a stored item, got %v %+v", job.Status, job.Items)
+		}
+		if rng, _ := resumed.Load().(string); rng != "" {
+			t.Fatalf("expected a download from scratch, got range %q", rng)
+		}
+	}
+
+	t.Run("finished job", func(t *testing.T) {
+		srv, _, resumed := newServer(false)
+		defer srv.Close()
+
+		u := newUseCase()
+
+		created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.URL+"/a.txt"), entity.DownloadOptions{
+			RetryPolicy: &entity.RetryPolicy{MaxAttempts: 1},
+		})
+		if err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		job := waitJob(t, u, created.ID)
+		if job.Status != entity.Done || job.Items[0].State != entity.ItemFailed {
+			t.Fatalf("expected Done job with a failed item, got %v %+v", job.Status, job.Items)
+		}
+		if received := job.Items[0].BytesReceived; received != 0 {
+			t.Fatalf("expected no bytes left to resume from, got %d", received)
+		}
+
+		resumeFromScratch(t, u, created.ID, resumed)
+	})
+
+	t.Run("job deadline", func(t *testing.T) {
+		srv, _, resumed := newServer(true)
+		defer srv.Close()
+
+		u := newUseCase()
+
+		created, err := u.StartJob(context.Background(), 300*time.Millisecond, sources(srv.URL+"/a.txt"), entity.DownloadOptions{})
+		if err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		job := waitJob(t, u, created.ID)
+		if job.Status != entity.Failed || job.Items[0].BytesReceived != int64(len(payload)/2) {
+			t.Fatalf("expected Failed job with half the file received, got %v %+v", job.Status, job.Items)
+		}
+
+		if _, err := u.ResumeJob(context.Background(), created.ID); err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		job = waitJob(t, u, created.ID)
+		if job.Status != entity.Done || job.Items[0].State != entity.ItemDone {
+			t.Fatalf("expected Done job with a stored item, got %v %+v", job.Status, job.Items)
+		}
+		if rng, _ := resumed.Load().(string); rng != fmt.Sprintf("bytes=%d-", len(payload)/2) {
+			t.Fatalf("expected a resume from the checkpoint, got range %q", rng)
+		}
+	})
+
+	t.Run("expired checkpoint", func(t *testing.T) {
+		srv, stalled, resumed := newServer(true)
+		defer srv.Close()
+
+		u := newUseCase(usecases.WithCheckpointLimits(time.Millisecond, 0))
+
+		created, err := u.StartJob(context.Background(), 5*time.Second, sources(srv.URL+"/a.txt"), entity.DownloadOptions{})
+		if err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		<-stalled
+		if _, err := u.PauseJob(context.Background(), created.ID); err != nil {
+			t.Fatalf("expected nil err, got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		resumeFromScratch(t, u, created.ID, resumed)
+	})
+}
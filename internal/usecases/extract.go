@@ -0,0 +1,274 @@
+package usecases
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"gin-quickstart/internal/domain/entity"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultExtractMaxEntries = 1000
+	defaultExtractMaxBytes   = int64(100 << 20) // 100mb
+)
+
+var (
+	errNotArchive        = errors.New("file is not a zip or tar archive")
+	errUnsafeArchivePath = errors.New("archive entry points outside the archive")
+	errArchiveTooLarge   = errors.New("archive exceeds the extraction limits")
+)
+
+// WithExtractLimits caps the number of entries of an extracted archive and
+// the total bytes they unpack to. Zero removes a cap.
+func WithExtractLimits(maxEntries int, maxBytes int64) Option {
+	return func(u *DownloadUseCase) {
+		u.extractMaxEntries = maxEntries
+		u.extractMaxBytes = maxBytes
+	}
+}
+
+// entryPath returns the path of an archive entry, rejecting absolute paths
+// and paths that climb out of the archive.
+func entryPath(name string) (string, error) {
+	if strings.Contains(name, `\`) || path.IsAbs(name) {
+		return "", errUnsafeArchivePath
+	}
+	cleaned := path.Clean(name)
+	if cleaned == "." || !fs.ValidPath(cleaned) {
+		return "", errUnsafeArchivePath
+	}
+	return cleaned, nil
+}
+
+// extraction stores the entries of one archive. The entries are charged to
+// the limits of the job like downloaded files, on top of the extraction limits
+// that guard against archives unpacking to far more than their own size.
+type extraction struct {
+	u        *DownloadUseCase
+	t        *itemTask
+	limits   *downloadLimits
+	entries  int
+	unpacked int64
+	children []entity.ExtractedFile
+}
+
+// budgetReader fails once the archive unpacked more bytes than allowed. The
+// sizes in archive headers are not trusted, only the bytes actually read.
+type budgetReader struct {
+	r io.Reader
+	x *extraction
+}
+
+func (b budgetReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.x.unpacked += int64(n)
+	if max := b.x.u.extractMaxBytes; max > 0 && b.x.unpacked > max {
+		return n, errArchiveTooLarge
+	}
+	return n, err
+}
+
+// entry counts one more entry of the archive and returns its checked path.
+func (x *extraction) entry(name string) (string, error) {
+	x.entries++
+	if max := x.u.extractMaxEntries; max > 0 && x.entries > max {
+		return "", errArchiveTooLarge
+	}
+	return entryPath(name)
+}
+
+func (x *extraction) store(ctx context.Context, name string, r io.Reader) error {
+	fw, err := x.u.FileRepository.Create(ctx)
+	if err != nil {
+		return err
+	}
+
+	startedAt := time.Now()
+	digest := sha256.New()
+	sniff := &sniffer{}
+	lr := x.limits.reader(r, 0)
+	if _, err := io.Copy(io.MultiWriter(fw, digest, sniff), lr); err != nil {
+		lr.release()
+		_ = fw.Abort()
+		return err
+	}
+
+	// entries have no declared type, the one of their extension stands in
+	metadata := describeFile(sniff.head, mime.TypeByExtension(path.Ext(name)), "", (&url.URL{Path: name}).String())
+	metadata.DeclaredMimeType = ""
+	metadata.SHA256 = hex.EncodeToString(digest.Sum(nil))
+	// an entry comes from the archive, which came from the source of the item
+	x.u.provenance(&metadata, x.t, fetchedFile{startedAt: startedAt})
+
+	metadata, err = fw.Commit(ctx, metadata)
+	if err != nil {
+		lr.release()
+		return err
+	}
+
+	x.children = append(x.children, entity.ExtractedFile{
+		Path:   name,
+		FileID: metadata.ID,
+		SHA256: metadata.SHA256,
+		Size:   metadata.Size,
+	})
+	return nil
+}
+
+// discard removes the entries stored so far and gives back their bytes.
+func (x *extraction) discard(ctx context.Context) {
+	for _, child := range x.children {
+		_ = x.u.FileRepository.Delete(context.WithoutCancel(ctx), child.FileID)
+		x.limits.quota.release(child.Size)
+	}
+	x.children = nil
+}
+
+func (x *extraction) unzip(ctx context.Context, r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return errors.Join(errNotArchive, err)
+	}
+
+	for _, f := range zr.File {
+		name, err := x.entry(f.Name)
+		if err != nil {
+			return err
+		}
+		// directories and links have no content of their own
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = x.store(ctx, name, budgetReader{r: rc, x: x})
+		_ = rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// untar reads a tar stream whole, so the bytes of the stream count against
+// the extraction limits rather than only those of the stored entries.
+func (x *extraction) untar(ctx context.Context, r io.Reader) error {
+	tr := tar.NewReader(budgetReader{r: r, x: x})
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Join(errNotArchive, err)
+		}
+
+		name, err := x.entry(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		if err := x.store(ctx, name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// seekReaderAt reads at an offset of a file that can only seek.
+type seekReaderAt struct {
+	mu sync.Mutex
+	rs io.ReadSeeker
+}
+
+func (s *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(s.rs, p)
+}
+
+// extract unpacks the zip, tar or tar.gz archive stored as fileID into a file
+// per entry. Nothing is kept when it fails.
+func (u *DownloadUseCase) extract(ctx context.Context, t *itemTask, fileID string) ([]entity.ExtractedFile, error) {
+	content, metadata, err := u.FileRepository.Open(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	// the format is told by the content, the name or type of the file may lie
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	x := &extraction{u: u, t: t, limits: t.run.limits}
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		ra, ok := content.(io.ReaderAt)
+		if !ok {
+			ra = &seekReaderAt{rs: content}
+		}
+		err = x.unzip(ctx, ra, metadata.Size)
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(content); err == nil {
+			err = x.untar(ctx, gz)
+		} else {
+			err = errors.Join(errNotArchive, err)
+		}
+	case len(head) > 262 && string(head[257:262]) == "ustar":
+		err = x.untar(ctx, content)
+	default:
+		err = errNotArchive
+	}
+	if err != nil {
+		x.discard(ctx)
+		return nil, err
+	}
+	return x.children, nil
+}
+
+// extractItem adds the entries of the archive of a downloaded item as its
+// children. An archive that cannot be extracted safely fails the item and is
+// dropped.
+func (u *DownloadUseCase) extractItem(ctx context.Context, t *itemTask, item entity.DownloadItem) (entity.DownloadItem, error) {
+	children, err := u.extract(ctx, t, item.FileID)
+	if err != nil {
+		_ = u.FileRepository.Delete(context.WithoutCancel(ctx), item.FileID)
+		t.run.limits.quota.release(item.BytesReceived)
+		item.State = entity.ItemFailed
+		item.FileID = ""
+		item.SHA256 = ""
+		item.Error = &entity.DownloadItemError{Code: getErrorCode(err)}
+		return item, err
+	}
+	item.Children = children
+	return item, nil
+}
@@ -0,0 +1,124 @@
+package usecases
+
+import (
+	"gin-quickstart/internal/domain/entity"
+	"gin-quickstart/pkg/bandwidth"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// itemProgress counts the bytes of an item while it downloads.
+type itemProgress struct {
+	received atomic.Int64
+	total    atomic.Int64
+	meter    *bandwidth.Meter
+}
+
+func newItemProgress() *itemProgress {
+	p := &itemProgress{meter: bandwidth.NewMeter()}
+	p.total.Store(-1)
+	return p
+}
+
+// reset restarts the count at offset bytes of a file of total bytes, -1 if unknown.
+func (p *itemProgress) reset(offset, total int64) {
+	p.received.Store(offset)
+	p.total.Store(total)
+}
+
+func (p *itemProgress) Reader(r io.Reader) io.Reader {
+	return &progressReader{r: r, progress: p}
+}
+
+type progressReader struct {
+	r        io.Reader
+	progress *itemProgress
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	if n > 0 {
+		pr.progress.received.Add(int64(n))
+		pr.progress.meter.Add(n)
+	}
+	return n, err
+}
+
+type jobCollector struct {
+	mu       sync.Mutex
+	job      *entity.DownloadJob
+	progress map[int]*itemProgress
+	events   *eventBroker
+}
+
+func NewJobCollector(job *entity.DownloadJob, events *eventBroker) *jobCollector {
+	return &jobCollector{
+		job:      job,
+		progress: make(map[int]*itemProgress),
+		events:   events,
+	}
+}
+
+func (jc *jobCollector) publish(eventType entity.JobEventType, index int, item entity.DownloadItem) {
+	jc.events.publish(entity.JobEvent{
+		JobID: jc.job.ID,
+		Type:  eventType,
+		Index: index,
+		Item:  item,
+	})
+}
+
+// start marks the item at index as downloading and returns its progress counter.
+func (jc *jobCollector) start(index int) (entity.DownloadItem, *itemProgress) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+
+	p := newItemProgress()
+	jc.progress[index] = p
+	jc.job.Items[index].State = entity.ItemDownloading
+	jc.publish(entity.EventItemStarted, index, jc.job.Items[index])
+	return jc.job.Items[index], p
+}
+
+// finish stores the outcome of the item at index.
+func (jc *jobCollector) finish(index int, item entity.DownloadItem) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+
+	delete(jc.progress, index)
+	jc.job.Items[index] = item
+
+	switch item.State {
+	case entity.ItemDone:
+		jc.publish(entity.EventItemDone, index, item)
+	case entity.ItemFailed:
+		jc.publish(entity.EventItemFailed, index, item)
+	default:
+		jc.publish(entity.EventItemInterrupted, index, item)
+	}
+}
+
+// publishProgress publishes the progress of the items in flight in a snapshot.
+func (jc *jobCollector) publishProgress(job entity.DownloadJob) {
+	for i, item := range job.Items {
+		if item.State == entity.ItemDownloading {
+			jc.publish(entity.EventItemProgress, i, item)
+		}
+	}
+}
+
+// snapshot returns a copy of the job with the live progress of the items in flight.
+func (jc *jobCollector) snapshot() entity.DownloadJob {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+
+	job := *jc.job
+	job.Items = append([]entity.DownloadItem(nil), jc.job.Items...)
+	for i, p := range jc.progress {
+		job.Items[i].BytesReceived = p.received.Load()
+		job.Items[i].BytesTotal = p.total.Load()
+		job.Items[i].Speed = p.meter.Rate()
+	}
+	return job
+}
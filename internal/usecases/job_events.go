@@ -0,0 +1,108 @@
+package usecases
+
+import (
+	"gin-quickstart/internal/domain/entity"
+	"sync"
+	"time"
+)
+
+const (
+	// maxJobEvents bounds the events kept per job for clients that reconnect.
+	maxJobEvents = 1024
+	// jobEventsTTL is how long the events of a finished job stay available.
+	jobEventsTTL = 5 * time.Minute
+)
+
+// jobEventLog holds the recent events of one job. changed is closed and
+// replaced on every new event, waking up the subscribers.
+type jobEventLog struct {
+	events  []entity.JobEvent
+	changed chan struct{}
+	closed  bool
+	expire  *time.Timer
+}
+
+// eventBroker fans out the events of running jobs to their subscribers.
+type eventBroker struct {
+	mu   sync.Mutex
+	seq  int64
+	logs map[string]*jobEventLog
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{logs: make(map[string]*jobEventLog)}
+}
+
+func (b *eventBroker) log(jobID string) *jobEventLog {
+	l, exists := b.logs[jobID]
+	if !exists {
+		l = &jobEventLog{changed: make(chan struct{})}
+		b.logs[jobID] = l
+	}
+	return l
+}
+
+func (b *eventBroker) publish(event entity.JobEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	event.ID = b.seq
+	event.At = time.Now()
+
+	l := b.log(event.JobID)
+	if len(l.events) == maxJobEvents {
+		l.events = append(l.events[:0], l.events[1:]...)
+	}
+	l.events = append(l.events, event)
+
+	// a resumed job reopens its log
+	if l.expire != nil {
+		l.expire.Stop()
+		l.expire = nil
+	}
+	l.closed = event.Terminal()
+	if l.closed {
+		l.expire = time.AfterFunc(jobEventsTTL, func() { b.drop(event.JobID, l) })
+	}
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (b *eventBroker) drop(jobID string, l *jobEventLog) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.logs[jobID] == l && l.closed {
+		delete(b.logs, jobID)
+	}
+}
+
+// since returns the events of jobID after the event afterID, a channel closed
+// once more events are published and whether the job has ended. It reports
+// false when no events of the job are known.
+func (b *eventBroker) since(jobID string, afterID int64) ([]entity.JobEvent, <-chan struct{}, bool, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	l, exists := b.logs[jobID]
+	if !exists {
+		return nil, nil, false, false
+	}
+
+	i := len(l.events)
+	for i > 0 && l.events[i-1].ID > afterID {
+		i--
+	}
+	return append([]entity.JobEvent(nil), l.events[i:]...), l.changed, l.closed, true
+}
+
+// watch makes sure a log exists for jobID so a subscriber can wait for the
+// first events of a job that is not running yet.
+func (b *eventBroker) watch(jobID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.log(jobID)
+}
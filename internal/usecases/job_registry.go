@@ -0,0 +1,57 @@
+package usecases
+
+import (
+	"context"
+	"gin-quickstart/pkg/bandwidth"
+	"sync"
+)
+
+// runningJob is the handle of a job whose runJob is in progress.
+type runningJob struct {
+	cancel context.CancelCauseFunc
+	pause  context.CancelFunc
+	// pauseCtx is done once the job is asked to pause.
+	pauseCtx context.Context
+	// bandwidth is the part of the download bandwidth the job may use.
+	bandwidth *bandwidth.Share
+	// done is closed once runJob has stored the final state of the job.
+	done chan struct{}
+}
+
+// jobRegistry tracks the jobs currently running in this process, so they can
+// be controlled from other requests and never run twice at the same time.
+type jobRegistry struct {
+	mu   sync.Mutex
+	jobs map[string]*runningJob
+}
+
+func newJobRegistry() *jobRegistry {
+	return &jobRegistry{jobs: make(map[string]*runningJob)}
+}
+
+func (r *jobRegistry) register(jobID string, rj *runningJob) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.jobs[jobID]; exists {
+		return false
+	}
+
+	r.jobs[jobID] = rj
+	return true
+}
+
+func (r *jobRegistry) unregister(jobID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.jobs, jobID)
+}
+
+func (r *jobRegistry) get(jobID string) (*runningJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rj, exists := r.jobs[jobID]
+	return rj, exists
+}
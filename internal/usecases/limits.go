@@ -0,0 +1,189 @@
+package usecases
+
+import (
+	"errors"
+	"gin-quickstart/internal/domain/entity"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+const defaultMimeType = "application/octet-stream"
+
+var (
+	errTooLarge         = errors.New("file exceeds the maximum size")
+	errJobQuotaExceeded = errors.New("job exceeds its maximum total bytes")
+	errMimeNotAllowed   = errors.New("content type is not allowed")
+)
+
+func isLimitErr(err error) bool {
+	return errors.Is(err, errTooLarge) || errors.Is(err, errJobQuotaExceeded) || errors.Is(err, errMimeNotAllowed)
+}
+
+// WithMaxFileSize caps the size of every file; jobs may only ask for less.
+// Zero removes the cap.
+func WithMaxFileSize(bytes int64) Option {
+	return func(u *DownloadUseCase) {
+		u.maxFileSize = bytes
+	}
+}
+
+// WithMaxJobBytes caps the total bytes a job may store; jobs may only ask for
+// less. Zero removes the cap.
+func WithMaxJobBytes(bytes int64) Option {
+	return func(u *DownloadUseCase) {
+		u.maxJobBytes = bytes
+	}
+}
+
+// capLimit returns the stricter of two limits where zero means unlimited.
+func capLimit(ceiling, requested int64) int64 {
+	if requested > 0 && (ceiling <= 0 || requested < ceiling) {
+		return requested
+	}
+	return ceiling
+}
+
+// jobQuota counts the bytes a job stores against its maximum total.
+type jobQuota struct {
+	max  int64
+	used atomic.Int64
+}
+
+func (q *jobQuota) reserve(n int64) error {
+	if used := q.used.Add(n); q.max > 0 && used > q.max {
+		q.used.Add(-n)
+		return errJobQuotaExceeded
+	}
+	return nil
+}
+
+func (q *jobQuota) release(n int64) {
+	q.used.Add(-n)
+}
+
+// downloadLimits are the rules every item of a job run must satisfy.
+type downloadLimits struct {
+	maxFileSize int64
+	quota       *jobQuota
+	allowed     []string
+	denied      []string
+}
+
+func (u *DownloadUseCase) limitsFor(job entity.DownloadJob) *downloadLimits {
+	l := &downloadLimits{
+		maxFileSize: capLimit(u.maxFileSize, job.Options.MaxFileSize),
+		quota:       &jobQuota{max: capLimit(u.maxJobBytes, job.Options.MaxJobBytes)},
+		allowed:     job.Options.AllowedMimeTypes,
+		denied:      job.Options.DeniedMimeTypes,
+	}
+	// the files already stored by an earlier run count against the quota
+	for _, item := range job.Items {
+		if item.State == entity.ItemDone {
+			l.quota.used.Add(item.BytesReceived)
+		}
+		for _, child := range item.Children {
+			l.quota.used.Add(child.Size)
+		}
+	}
+	return l
+}
+
+// matchMimeType reports whether the media type matches one of patterns, such
+// as "application/pdf" or "image/*".
+func matchMimeType(mediaType string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(strings.ToLower(pattern), mediaType); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkType rejects content types on the deny list or missing from a non
+// empty allow list.
+func (l *downloadLimits) checkType(contentType string) error {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = defaultMimeType
+	}
+	if matchMimeType(mediaType, l.denied) {
+		return errMimeNotAllowed
+	}
+	if len(l.allowed) > 0 && !matchMimeType(mediaType, l.allowed) {
+		return errMimeNotAllowed
+	}
+	return nil
+}
+
+// checkDeclaredType checks the type an upstream declared, before any content
+// arrived. A missing or generic type says nothing yet, the type sniffed from
+// the content is checked instead once it is known.
+func (l *downloadLimits) checkDeclaredType(contentType string) error {
+	if isGenericMimeType(contentType) {
+		return nil
+	}
+	return l.checkType(contentType)
+}
+
+// checkSize rejects a file of size bytes up front, before it is downloaded. A
+// negative size is unknown and only checked while streaming.
+func (l *downloadLimits) checkSize(size int64) error {
+	if size < 0 {
+		return nil
+	}
+	if l.maxFileSize > 0 && size > l.maxFileSize {
+		return errTooLarge
+	}
+	if l.quota.max > 0 && l.quota.used.Load()+size > l.quota.max {
+		return errJobQuotaExceeded
+	}
+	return nil
+}
+
+// admit charges a file that is already stored, such as a cached one, to the
+// job if it satisfies the limits.
+func (l *downloadLimits) admit(metadata entity.FileMetadata) error {
+	if err := l.checkType(metadata.MimeType); err != nil {
+		return err
+	}
+	if err := l.checkSize(metadata.Size); err != nil {
+		return err
+	}
+	return l.quota.reserve(metadata.Size)
+}
+
+// reader enforces the limits on a file streamed from r after the first
+// offset bytes, which count too. What it charged to the quota must be
+// released if the file is not stored.
+func (l *downloadLimits) reader(r io.Reader, offset int64) *limitReader {
+	return &limitReader{r: r, limits: l, size: offset}
+}
+
+type limitReader struct {
+	r       io.Reader
+	limits  *downloadLimits
+	size    int64
+	charged int64
+}
+
+func (lr *limitReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.size += int64(n)
+
+	if lr.limits.maxFileSize > 0 && lr.size > lr.limits.maxFileSize {
+		return n, errTooLarge
+	}
+	if rerr := lr.limits.quota.reserve(lr.size - lr.charged); rerr != nil {
+		return n, rerr
+	}
+	lr.charged = lr.size
+	return n, err
+}
+
+func (lr *limitReader) release() {
+	lr.limits.quota.release(lr.charged)
+	lr.charged = 0
+}
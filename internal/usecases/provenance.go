@@ -0,0 +1,74 @@
+package usecases
+
+import (
+	"gin-quickstart/internal/domain/entity"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultProvenanceHeaders are the upstream response headers kept with every
+// stored file. Range and length headers are left out: they describe the
+// response, not the file.
+var DefaultProvenanceHeaders = []string{
+	"Content-Type",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-MD5",
+	"Digest",
+	"Repr-Digest",
+	"ETag",
+	"Last-Modified",
+	"Cache-Control",
+	"Expires",
+	"Date",
+	"Server",
+}
+
+// WithProvenanceHeaders replaces the upstream response headers kept with
+// every stored file.
+func WithProvenanceHeaders(names ...string) Option {
+	return func(u *DownloadUseCase) {
+		u.provenanceHeaders = names
+	}
+}
+
+// RedactURL drops the user and password of rawURL. Source URLs go through it
+// wherever they are logged or leave the service.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	return u.String()
+}
+
+// fetchedFile is what a transfer learnt about a file besides its content.
+type fetchedFile struct {
+	etag         string
+	lastModified string
+	header       http.Header
+	startedAt    time.Time
+}
+
+// provenance records on metadata where the file of t came from and when.
+func (u *DownloadUseCase) provenance(metadata *entity.FileMetadata, t *itemTask, f fetchedFile) {
+	metadata.SourceURL = RedactURL(t.source.URL)
+	metadata.FinalURL = RedactURL(t.sourceURL())
+	metadata.ETag = f.etag
+	metadata.LastModified = f.lastModified
+	metadata.StartedAt = f.startedAt
+	metadata.FinishedAt = time.Now()
+
+	for _, name := range u.provenanceHeaders {
+		if values := f.header.Values(name); len(values) > 0 {
+			if metadata.Headers == nil {
+				metadata.Headers = make(map[string]string)
+			}
+			metadata.Headers[http.CanonicalHeaderKey(name)] = strings.Join(values, ", ")
+		}
+	}
+}
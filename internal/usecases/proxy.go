@@ -0,0 +1,230 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gin-quickstart/pkg/egress"
+	"gin-quickstart/pkg/netguard"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"golang.org/x/net/proxy"
+)
+
+// WithProxies sends downloads through the proxies router picks for their
+// hosts. A job may name its own proxy instead. FTP only goes through SOCKS5
+// proxies.
+func WithProxies(router *egress.Router) Option {
+	return func(u *DownloadUseCase) {
+		u.proxies = router
+	}
+}
+
+var errProxyUnsupported = errors.New("proxy cannot carry the protocol")
+
+type contextDialer interface {
+	proxy.Dialer
+	proxy.ContextDialer
+}
+
+// jobProxy is the proxy a job names instead of the routes of the service. Its
+// transport lives as long as the job run, not the service, as a job may name
+// any proxy it likes.
+type jobProxy struct {
+	url *url.URL
+
+	once      sync.Once
+	transport *http.Transport
+	err       error
+}
+
+func newJobProxy(proxyURL *url.URL) *jobProxy {
+	if proxyURL == nil {
+		return nil
+	}
+	return &jobProxy{url: proxyURL}
+}
+
+// close drops the connections of the job to its proxy once the job ended.
+func (j *jobProxy) close() {
+	if j != nil && j.transport != nil {
+		j.transport.CloseIdleConnections()
+	}
+}
+
+// proxyTransport is the RoundTripper of the download client. Every request,
+// each redirect hop included, goes through the proxy of its job or of the
+// router, or connects directly through the network guard.
+type proxyTransport struct {
+	guard  *netguard.Guard
+	router *egress.Router
+	direct *http.Transport
+
+	// transports holds one transport per proxy of the router.
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+// downloadTransport sets the timeouts of the connections a download makes.
+// The transfer itself is bounded by the job, not the client.
+func downloadTransport(transport *http.Transport) *http.Transport {
+	transport.ResponseHeaderTimeout = responseHeaderTimeout
+	return transport
+}
+
+func newProxyTransport(guard *netguard.Guard, router *egress.Router) *proxyTransport {
+	return &proxyTransport{
+		guard:      guard,
+		router:     router,
+		direct:     downloadTransport(guard.Transport()),
+		transports: make(map[string]*http.Transport),
+	}
+}
+
+func (p *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace, _ := req.Context().Value(requestTraceKey{}).(*requestTrace)
+
+	proxyURL := p.router.ProxyFor(req.URL.Hostname())
+	var job *jobProxy
+	if trace != nil && trace.proxy != nil {
+		job = trace.proxy
+		proxyURL = job.url
+	}
+
+	if trace != nil {
+		trace.via = ""
+	}
+	if proxyURL == nil {
+		return p.direct.RoundTrip(req)
+	}
+
+	// the proxy resolves the host, so only the name can be checked here
+	if err := p.guard.CheckURL(req.URL); err != nil {
+		return nil, err
+	}
+	var transport *http.Transport
+	var err error
+	if job != nil {
+		job.once.Do(func() {
+			// connecting to a proxy named by a job goes through the network
+			// guard like any other destination a job names
+			job.transport, job.err = newTransport(proxyURL, p.guard)
+		})
+		transport, err = job.transport, job.err
+	} else {
+		transport, err = p.transport(proxyURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if trace != nil {
+		trace.via = proxyURL.Redacted()
+	}
+	return transport.RoundTrip(req)
+}
+
+// dialFTP is the dialer of the FTP fetcher. FTP goes through the proxy of its
+// job or of the router like HTTP does, which only works with a SOCKS5 proxy: an
+// HTTP proxy fails the item rather than being bypassed.
+func (p *proxyTransport) dialFTP(ctx context.Context, network, address string) (net.Conn, error) {
+	trace, _ := ctx.Value(requestTraceKey{}).(*requestTrace)
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyURL := p.router.ProxyFor(host)
+	var forward contextDialer = &net.Dialer{Timeout: dialTimeout}
+	if trace != nil && trace.proxy != nil {
+		proxyURL, forward = trace.proxy.url, p.guard
+	}
+
+	if trace != nil {
+		trace.via = ""
+	}
+	if proxyURL == nil {
+		return p.guard.DialContext(ctx, network, address)
+	}
+	if proxyURL.Scheme != "socks5" && proxyURL.Scheme != "socks5h" {
+		return nil, fmt.Errorf("%w: ftp through a %s proxy", errProxyUnsupported, proxyURL.Scheme)
+	}
+
+	// the proxy resolves the host, so only the name can be checked here
+	if err := p.guard.CheckHost(host); err != nil {
+		return nil, err
+	}
+	dialer, err := proxy.FromURL(proxyURL, forward)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if trace != nil {
+		trace.via = proxyURL.Redacted()
+	}
+	return proxiedConn{Conn: conn, remote: proxiedAddr{network: network, address: address}}, nil
+}
+
+// proxiedConn is a connection through a proxy. Its remote address is the one
+// it reaches rather than the proxy, so FTP data connections go to the same
+// host through the same proxy.
+type proxiedConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c proxiedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+type proxiedAddr struct {
+	network, address string
+}
+
+func (a proxiedAddr) Network() string { return a.network }
+func (a proxiedAddr) String() string  { return a.address }
+
+// transport returns the transport through a proxy of the router, which are
+// few and set up by the service, so they are kept for good.
+func (p *proxyTransport) transport(proxyURL *url.URL) (*http.Transport, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := proxyURL.String()
+	if transport, exists := p.transports[key]; exists {
+		return transport, nil
+	}
+
+	transport, err := newTransport(proxyURL, &net.Dialer{Timeout: dialTimeout})
+	if err != nil {
+		return nil, err
+	}
+	p.transports[key] = transport
+	return transport, nil
+}
+
+// newTransport returns a transport through proxyURL, connecting to the proxy
+// with forward.
+func newTransport(proxyURL *url.URL, forward contextDialer) (*http.Transport, error) {
+	transport := downloadTransport(http.DefaultTransport.(*http.Transport).Clone())
+	switch proxyURL.Scheme {
+	case "http", "https":
+		transport.Proxy = http.ProxyURL(proxyURL)
+		transport.DialContext = forward.DialContext
+	case "socks5", "socks5h":
+		dialer, err := proxy.FromURL(proxyURL, forward)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = nil
+		transport.DialContext = dialer.(proxy.ContextDialer).DialContext
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+	return transport, nil
+}
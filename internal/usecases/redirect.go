@@ -0,0 +1,80 @@
+package usecases
+
+import (
+	"errors"
+	"fmt"
+	"gin-quickstart/internal/domain/entity"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var DefaultRedirectPolicy = entity.RedirectPolicy{
+	MaxRedirects:   10,
+	AllowDowngrade: false,
+	AllowCrossHost: true,
+}
+
+var errRedirectNotAllowed = errors.New("redirect not allowed")
+
+func checkRedirectPolicy(p entity.RedirectPolicy, req *http.Request, via []*http.Request) error {
+	if len(via) > p.MaxRedirects {
+		return fmt.Errorf("%w: more than %d redirects", errRedirectNotAllowed, p.MaxRedirects)
+	}
+	if prev := via[len(via)-1].URL; !p.AllowDowngrade && prev.Scheme == "https" && req.URL.Scheme == "http" {
+		return fmt.Errorf("%w: downgrade from https to http", errRedirectNotAllowed)
+	}
+	if origin := via[0].URL; !p.AllowCrossHost && !strings.EqualFold(origin.Hostname(), req.URL.Hostname()) {
+		return fmt.Errorf("%w: from %s to %s", errRedirectNotAllowed, origin.Hostname(), req.URL.Hostname())
+	}
+	return nil
+}
+
+// checkRedirectChain checks the redirects a file was once downloaded through
+// from source against p, as if they were followed again.
+func checkRedirectChain(p entity.RedirectPolicy, source string, hops []entity.Redirect) error {
+	origin, err := url.Parse(source)
+	if err != nil {
+		return err
+	}
+	via := []*http.Request{{URL: origin}}
+	for _, hop := range hops {
+		next, err := url.Parse(hop.URL)
+		if err != nil {
+			return err
+		}
+		req := &http.Request{URL: next}
+		if err := checkRedirectPolicy(p, req, via); err != nil {
+			return err
+		}
+		via = append(via, req)
+	}
+	return nil
+}
+
+// checkRedirect is the CheckRedirect of the download client. It records the
+// hop on the trace of the request, then checks it against the policy of the
+// job and the network guard. The headers of the job and the file are dropped
+// once a redirect leaves the host of the source: they may carry API keys the
+// client itself only knows to strip for Authorization and Cookie.
+func (u *DownloadUseCase) checkRedirect(req *http.Request, via []*http.Request) error {
+	trace, ok := req.Context().Value(requestTraceKey{}).(*requestTrace)
+	if !ok {
+		return u.guard.CheckRedirect(req, via)
+	}
+
+	trace.hops = append(trace.hops, entity.Redirect{
+		URL:        req.URL.Redacted(),
+		StatusCode: req.Response.StatusCode,
+	})
+
+	if err := checkRedirectPolicy(trace.redirectPolicy, req, via); err != nil {
+		return err
+	}
+	if !strings.EqualFold(via[0].URL.Host, req.URL.Host) {
+		for _, name := range trace.headers {
+			req.Header.Del(name)
+		}
+	}
+	return u.guard.CheckURL(req.URL)
+}
@@ -0,0 +1,76 @@
+package usecases
+
+import (
+	"encoding/base64"
+	"gin-quickstart/internal/domain/entity"
+	"net/http"
+)
+
+const defaultUserAgent = "go-school-downloader/1.0"
+
+// WithUserAgent sets the User-Agent sent upstream unless a job or file sets
+// its own.
+func WithUserAgent(userAgent string) Option {
+	return func(u *DownloadUseCase) {
+		u.userAgent = userAgent
+	}
+}
+
+// requestTrace carries the settings of a job to the client for one request,
+// which records on it the redirects it follows and the proxy it goes through.
+type requestTrace struct {
+	redirectPolicy entity.RedirectPolicy
+	// proxy, if set, replaces the proxy routes of the service.
+	proxy *jobProxy
+	// headers are the names of the headers set by the job or the file, which
+	// only go to the host of the source.
+	headers []string
+
+	hops []entity.Redirect
+	// via is the proxy of the last hop without its credentials, empty when
+	// the hop connected directly.
+	via string
+}
+
+type requestTraceKey struct{}
+
+func hasCredentials(s entity.RequestSettings) bool {
+	return s.BasicAuth != nil || s.BearerToken != ""
+}
+
+// requestHeader returns the headers and credentials to send for the source of
+// t. Those of the file take precedence over those of the job.
+func (t *itemTask) requestHeader() http.Header {
+	job, file := t.run.options.Request, t.source.Request
+
+	header := http.Header{}
+	for _, s := range []entity.RequestSettings{job, file} {
+		for name, value := range s.Headers {
+			header.Set(name, value.Reveal())
+		}
+	}
+
+	auth := file
+	if !hasCredentials(auth) {
+		auth = job
+	}
+	switch {
+	case auth.BasicAuth != nil:
+		credentials := auth.BasicAuth.Username + ":" + auth.BasicAuth.Password.Reveal()
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	case auth.BearerToken != "":
+		header.Set("Authorization", "Bearer "+auth.BearerToken.Reveal())
+	}
+	return header
+}
+
+// private reports whether the requests for t carry headers or credentials. A
+// shared cache must not hand what they return to other jobs.
+func (t *itemTask) private() bool {
+	for _, s := range []entity.RequestSettings{t.run.options.Request, t.source.Request} {
+		if len(s.Headers) > 0 || hasCredentials(s) {
+			return true
+		}
+	}
+	return false
+}
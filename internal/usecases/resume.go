@@ -0,0 +1,192 @@
+package usecases
+
+import (
+	"errors"
+	"gin-quickstart/internal/domain/entity"
+	"gin-quickstart/internal/domain/ports"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errRangeMismatch = errors.New("upstream returned an unexpected content range")
+
+// partialDownload is the checkpoint of an interrupted transfer: the still
+// uncommitted file writer, how many bytes it holds, their running digest and
+// the validators needed to make sure a resumed request continues the very
+// same representation.
+type partialDownload struct {
+	writer       ports.FileWriter
+	digest       *digester
+	sniff        *sniffer
+	offset       int64
+	resumable    bool
+	etag         string
+	lastModified string
+	contentType  string
+	// header is the response header the transfer started with.
+	header    http.Header
+	startedAt time.Time
+}
+
+func newPartialDownload(fw ports.FileWriter, resp *ports.FetchResponse, expected *entity.Checksum) *partialDownload {
+	return &partialDownload{
+		writer:       fw,
+		digest:       newDigester(expected),
+		sniff:        &sniffer{},
+		resumable:    resp.Resumable,
+		etag:         resp.ETag,
+		lastModified: resp.LastModified,
+		contentType:  resp.ContentType,
+		header:       resp.Header,
+		startedAt:    time.Now(),
+	}
+}
+
+// Write stores p in the file and adds what was stored to the digest and the
+// sniffed head of the file.
+func (p *partialDownload) Write(b []byte) (int, error) {
+	n, err := p.writer.Write(b)
+	_, _ = p.digest.Write(b[:n])
+	_, _ = p.sniff.Write(b[:n])
+	return n, err
+}
+
+// applyRange asks the upstream for the bytes after the checkpoint. The
+// validator makes the upstream send the whole file again if it changed.
+func (p *partialDownload) applyRange(req *ports.FetchRequest) {
+	if p == nil || p.offset == 0 {
+		return
+	}
+
+	req.Offset = p.offset
+	if p.etag != "" && !strings.HasPrefix(p.etag, "W/") {
+		req.Validator = p.etag
+	} else {
+		req.Validator = p.lastModified
+	}
+}
+
+func (p *partialDownload) discard() {
+	if p != nil {
+		_ = p.writer.Abort()
+	}
+}
+
+const (
+	defaultCheckpointTTL      = time.Hour
+	defaultCheckpointMaxBytes = int64(256 << 20) // 256mb
+)
+
+// WithCheckpointLimits bounds how long checkpoints of paused and failed jobs
+// are kept and how many bytes they may hold together; the oldest go first.
+// Zero removes a bound.
+func WithCheckpointLimits(ttl time.Duration, maxBytes int64) Option {
+	return func(u *DownloadUseCase) {
+		u.partials.ttl = ttl
+		u.partials.maxBytes = maxBytes
+	}
+}
+
+type partialKey struct {
+	jobID string
+	index int
+}
+
+type partialEntry struct {
+	partial  *partialDownload
+	storedAt time.Time
+}
+
+// partialStore keeps checkpoints of failed transfers between the runs of a
+// paused or failed job, so a resumed job continues where the previous run
+// stopped.
+type partialStore struct {
+	mu       sync.Mutex
+	partials map[partialKey]partialEntry
+	ttl      time.Duration
+	maxBytes int64
+	bytes    int64
+}
+
+func newPartialStore() *partialStore {
+	return &partialStore{
+		partials: make(map[partialKey]partialEntry),
+		ttl:      defaultCheckpointTTL,
+		maxBytes: defaultCheckpointMaxBytes,
+	}
+}
+
+func (s *partialStore) remove(key partialKey) *partialDownload {
+	e, exists := s.partials[key]
+	if !exists {
+		return nil
+	}
+	delete(s.partials, key)
+	s.bytes -= e.partial.offset
+	return e.partial
+}
+
+// evict discards the expired checkpoints, then the oldest ones while the
+// store holds more bytes than allowed.
+func (s *partialStore) evict(now time.Time) {
+	for key, e := range s.partials {
+		if s.ttl > 0 && now.Sub(e.storedAt) > s.ttl {
+			s.remove(key).discard()
+		}
+	}
+	for s.maxBytes > 0 && s.bytes > s.maxBytes {
+		var oldest partialKey
+		var oldestAt time.Time
+		for key, e := range s.partials {
+			if oldestAt.IsZero() || e.storedAt.Before(oldestAt) {
+				oldest, oldestAt = key, e.storedAt
+			}
+		}
+		s.remove(oldest).discard()
+	}
+}
+
+func (s *partialStore) take(key partialKey) *partialDownload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict(time.Now())
+	return s.remove(key)
+}
+
+func (s *partialStore) put(key partialKey, p *partialDownload) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old := s.remove(key); old != nil && old != p {
+		old.discard()
+	}
+	s.partials[key] = partialEntry{partial: p, storedAt: time.Now()}
+	s.bytes += p.offset
+	s.evict(time.Now())
+}
+
+// dropCheckpoints discards the checkpoints of a job that will not be resumed
+// from them. Its unfinished items then have no bytes to continue from.
+func (u *DownloadUseCase) dropCheckpoints(job *entity.DownloadJob) {
+	u.partials.discardJob(job.ID)
+	for i := range job.Items {
+		if job.Items[i].State != entity.ItemDone {
+			job.Items[i].BytesReceived = 0
+		}
+	}
+}
+
+// discardJob drops the checkpoints of a job that will not be resumed.
+func (s *partialStore) discardJob(jobID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.partials {
+		if key.jobID == jobID {
+			s.remove(key).discard()
+		}
+	}
+}
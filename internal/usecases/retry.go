@@ -0,0 +1,111 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"gin-quickstart/internal/domain/entity"
+	"gin-quickstart/internal/domain/ports"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// jitter returns the Jitter of a retry policy.
+func jitter(fraction float64) *float64 {
+	return &fraction
+}
+
+var DefaultRetryPolicy = entity.RetryPolicy{
+	MaxAttempts: 3,
+	BaseBackoff: 500 * time.Millisecond,
+	MaxBackoff:  10 * time.Second,
+	Jitter:      jitter(0.2),
+	RetryableErrors: []entity.DownloadItemErrorCode{
+		entity.ErrorTimeout,
+		entity.ErrorNetwork,
+	},
+	RetryableStatusCodes: []int{
+		http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	},
+}
+
+// mergeRetryPolicy fills the zero fields of override from base. A zero
+// Jitter is kept, only a missing one is filled.
+func mergeRetryPolicy(base entity.RetryPolicy, override *entity.RetryPolicy) entity.RetryPolicy {
+	if override == nil {
+		return base
+	}
+
+	p := *override
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = base.MaxAttempts
+	}
+	if p.BaseBackoff <= 0 {
+		p.BaseBackoff = base.BaseBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = base.MaxBackoff
+	}
+	if p.Jitter == nil {
+		p.Jitter = base.Jitter
+	}
+	if p.RetryableErrors == nil {
+		p.RetryableErrors = base.RetryableErrors
+	}
+	if p.RetryableStatusCodes == nil {
+		p.RetryableStatusCodes = base.RetryableStatusCodes
+	}
+	return p
+}
+
+// isRetryable must only be asked while the job context is alive: a
+// DeadlineExceeded then comes from a request, not the job.
+func isRetryable(p entity.RetryPolicy, err error) bool {
+	var upstreamErr *ports.UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return slices.Contains(p.RetryableStatusCodes, upstreamErr.StatusCode)
+	}
+	return slices.Contains(p.RetryableErrors, getErrorCode(err))
+}
+
+// backoff returns how long to wait before the attempt following attempt.
+// An upstream Retry-After takes precedence over the exponential schedule, up
+// to MaxBackoff, so an upstream cannot hold a job slot for as long as it likes.
+func backoff(p entity.RetryPolicy, attempt int, err error) time.Duration {
+	var upstreamErr *ports.UpstreamError
+	if errors.As(err, &upstreamErr) && upstreamErr.RetryAfter > 0 {
+		if p.MaxBackoff > 0 {
+			return min(upstreamErr.RetryAfter, p.MaxBackoff)
+		}
+		return upstreamErr.RetryAfter
+	}
+
+	d := p.BaseBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, p.MaxBackoff)
+
+	if p.Jitter != nil && *p.Jitter > 0 {
+		d -= time.Duration(float64(d) * min(*p.Jitter, 1) * rand.Float64())
+	}
+	return d
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,245 @@
+package usecases
+
+import (
+	"context"
+	"gin-quickstart/internal/domain/entity"
+	"gin-quickstart/internal/domain/ports"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	maxSegments           = 16
+	defaultMinSegmentSize = int64(1 << 20) // 1mb
+)
+
+type byteRange struct {
+	first, last int64
+}
+
+func (r byteRange) size() int64 {
+	return r.last - r.first + 1
+}
+
+// splitRanges divides size bytes into n contiguous ranges of almost equal length.
+func splitRanges(size int64, n int) []byteRange {
+	ranges := make([]byteRange, n)
+	step := size / int64(n)
+	for i := range ranges {
+		ranges[i].first = int64(i) * step
+		ranges[i].last = ranges[i].first + step - 1
+	}
+	ranges[n-1].last = size - 1
+	return ranges
+}
+
+// probe is what a single byte range request reveals about a file.
+type probe struct {
+	size         int64
+	etag         string
+	lastModified string
+	contentType  string
+	header       http.Header
+}
+
+// probe asks for the first byte of the source of t to learn whether the
+// upstream serves ranges and how large the file is.
+func (u *DownloadUseCase) probe(ctx context.Context, t *itemTask) (probe, bool) {
+	trace := t.newTrace()
+	resp, err := u.fetch(ctx, ports.FetchRequest{URL: t.source.URL, Length: 1, Header: t.requestHeader()}, trace)
+	t.record(trace, resp)
+	if err != nil {
+		return probe{}, false
+	}
+	defer resp.Body.Close()
+
+	if !resp.Partial {
+		return probe{}, false
+	}
+
+	p := probe{
+		size:         resp.Size,
+		etag:         resp.ETag,
+		lastModified: resp.LastModified,
+		contentType:  resp.ContentType,
+		header:       resp.Header,
+	}
+	return p, p.size > 0
+}
+
+// segmentCount picks how many ranges a file of size bytes is split into. Every
+// segment past the first needs a free slot of the job, so a huge file only
+// uses capacity no other item is waiting for.
+func (run *jobRun) segmentCount(size int64) int {
+	minSize := run.options.MinSegmentSize
+	if minSize <= 0 {
+		minSize = defaultMinSegmentSize
+	}
+
+	n := min(run.options.Segments, maxSegments)
+	if bySize := size / minSize; bySize < int64(n) {
+		n = int(bySize)
+	}
+
+	extra := 0
+	for extra < n-1 && run.slots.TryAcquire(1) {
+		extra++
+	}
+	return 1 + extra
+}
+
+// segmentedTransfer downloads the source of t as parallel byte ranges and stores
+// them in order. It reports false when the upstream or the file size does not
+// qualify, in which case the caller falls back to a single request.
+func (u *DownloadUseCase) segmentedTransfer(ctx context.Context, t *itemTask, attempt *entity.DownloadAttempt) (entity.FileMetadata, bool, error) {
+	run := t.run
+
+	if run.options.Segments < 2 {
+		return entity.FileMetadata{}, false, nil
+	}
+
+	p, ok := u.probe(ctx, t)
+	if !ok {
+		return entity.FileMetadata{}, false, nil
+	}
+	if err := run.limits.checkDeclaredType(p.contentType); err != nil {
+		return entity.FileMetadata{}, true, err
+	}
+	if err := run.limits.checkSize(p.size); err != nil {
+		return entity.FileMetadata{}, true, err
+	}
+
+	n := run.segmentCount(p.size)
+	if n < 2 {
+		return entity.FileMetadata{}, false, nil
+	}
+	attempt.StatusCode = http.StatusPartialContent
+	defer run.slots.Release(int64(n - 1))
+	t.progress.reset(0, p.size)
+
+	// the size is known, so the whole file is charged to the job up front
+	if err := run.limits.quota.reserve(p.size); err != nil {
+		return entity.FileMetadata{}, true, err
+	}
+	stored := false
+	defer func() {
+		if !stored {
+			run.limits.quota.release(p.size)
+		}
+	}()
+
+	fw, err := u.FileRepository.Create(ctx)
+	if err != nil {
+		return entity.FileMetadata{}, true, err
+	}
+	startedAt := time.Now()
+
+	digest := newDigester(t.source.Checksum)
+	sniff := &sniffer{}
+	if err := u.fetchSegments(ctx, t, p, splitRanges(p.size, n), io.MultiWriter(fw, digest, sniff)); err != nil {
+		_ = fw.Abort()
+		return entity.FileMetadata{}, true, err
+	}
+
+	sum, err := digest.verify()
+	if err != nil {
+		_ = fw.Abort()
+		return entity.FileMetadata{}, true, err
+	}
+
+	metadata := describeFile(sniff.head, p.contentType, p.header.Get("Content-Disposition"), t.sourceURL())
+	if err := run.limits.checkType(metadata.MimeType); err != nil {
+		_ = fw.Abort()
+		return entity.FileMetadata{}, true, err
+	}
+	metadata.SHA256 = sum
+	u.provenance(&metadata, t, fetchedFile{
+		etag:         p.etag,
+		lastModified: p.lastModified,
+		header:       p.header,
+		startedAt:    startedAt,
+	})
+
+	metadata, err = fw.Commit(ctx, metadata)
+	if err != nil {
+		return entity.FileMetadata{}, true, err
+	}
+	stored = true
+	u.cacheFile(ctx, t, p.header, metadata)
+	return metadata, true, nil
+}
+
+// fetchSegments streams the first range straight into w and spools the
+// others to temporary files until it is their turn to be appended.
+func (u *DownloadUseCase) fetchSegments(ctx context.Context, t *itemTask, p probe, ranges []byteRange, w io.Writer) error {
+	spools := make([]*os.File, len(ranges))
+	defer func() {
+		for _, f := range spools {
+			if f != nil {
+				_ = f.Close()
+				_ = os.Remove(f.Name())
+			}
+		}
+	}()
+
+	g, gCtx := errgroup.WithContext(ctx)
+	for i, r := range ranges {
+		dst := w
+		if i > 0 {
+			f, err := os.CreateTemp("", "download-segment-*")
+			if err != nil {
+				return err
+			}
+			spools[i] = f
+			dst = f
+		}
+
+		g.Go(func() error {
+			return u.fetchSegment(gCtx, t, p.etag, r, dst)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	for _, f := range spools[1:] {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *DownloadUseCase) fetchSegment(ctx context.Context, t *itemTask, etag string, r byteRange, w io.Writer) error {
+	req := ports.FetchRequest{URL: t.source.URL, Offset: r.first, Length: r.size(), Header: t.requestHeader()}
+	if etag != "" && !strings.HasPrefix(etag, "W/") {
+		req.Validator = etag
+	}
+
+	resp, err := u.fetch(ctx, req, t.newTrace())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !resp.Partial || resp.Offset != r.first {
+		return errRangeMismatch
+	}
+
+	n, err := io.CopyN(w, t.progress.Reader(t.run.bandwidth.Reader(ctx, resp.Body)), r.size())
+	if err != nil {
+		return err
+	}
+	if n != r.size() {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package usecases
+
+import (
+	"bytes"
+	"gin-quickstart/internal/domain/entity"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// sniffLen is how many leading bytes of a file its type is detected from.
+	sniffLen = 512
+
+	maxFilenameLen  = 255
+	defaultFilename = "download"
+)
+
+// signature is the magic number of a file format, found at offset.
+type signature struct {
+	offset   int
+	magic    string
+	mimeType string
+	ext      string
+}
+
+// signatures cover the archives and documents http.DetectContentType does
+// not know, and the common formats whose extension is worth adding to a name.
+var signatures = []signature{
+	{0, "%PDF-", "application/pdf", ".pdf"},
+	{0, "PK\x03\x04", "application/zip", ".zip"},
+	{0, "PK\x05\x06", "application/zip", ".zip"},
+	{0, "\x1f\x8b", "application/gzip", ".gz"},
+	{257, "ustar", "application/x-tar", ".tar"},
+	{0, "BZh", "application/x-bzip2", ".bz2"},
+	{0, "\xfd7zXZ\x00", "application/x-xz", ".xz"},
+	{0, "\x28\xb5\x2f\xfd", "application/zstd", ".zst"},
+	{0, "7z\xbc\xaf\x27\x1c", "application/x-7z-compressed", ".7z"},
+	{0, "Rar!\x1a\x07", "application/vnd.rar", ".rar"},
+	{0, "\x89PNG\r\n\x1a\n", "image/png", ".png"},
+	{0, "\xff\xd8\xff", "image/jpeg", ".jpg"},
+	{0, "GIF87a", "image/gif", ".gif"},
+	{0, "GIF89a", "image/gif", ".gif"},
+	{0, "II*\x00", "image/tiff", ".tiff"},
+	{0, "MM\x00*", "image/tiff", ".tiff"},
+	{4, "ftypavif", "image/avif", ".avif"},
+	{4, "ftypheic", "image/heic", ".heic"},
+	{8, "WEBP", "image/webp", ".webp"},
+}
+
+// sniffer keeps the first sniffLen bytes written to it.
+type sniffer struct {
+	head []byte
+}
+
+func (s *sniffer) Write(p []byte) (int, error) {
+	if room := sniffLen - len(s.head); room > 0 {
+		s.head = append(s.head, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// detectMimeType returns the type of a file starting with head.
+func detectMimeType(head []byte) string {
+	for _, sig := range signatures {
+		if len(head) >= sig.offset && bytes.HasPrefix(head[sig.offset:], []byte(sig.magic)) {
+			return sig.mimeType
+		}
+	}
+	return http.DetectContentType(head)
+}
+
+// isGenericMimeType reports whether contentType says nothing about the content.
+func isGenericMimeType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	switch mediaType {
+	case defaultMimeType, "binary/octet-stream", "application/unknown", "application/x-unknown":
+		return true
+	}
+	return false
+}
+
+// safeFilename reduces name to its last path element without control or
+// reserved characters, or returns "" when nothing usable is left.
+func safeFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || unicode.IsControl(r):
+			return -1
+		case strings.ContainsRune(`"*:<>?|`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	// leading dots would hide the file, trailing ones are dropped by Windows
+	name = strings.Trim(name, " .")
+
+	if len(name) > maxFilenameLen {
+		ext := path.Ext(name)
+		if len(ext) > maxFilenameLen/2 {
+			ext = ""
+		}
+		base := name[:maxFilenameLen-len(ext)]
+		for !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+		name = base + ext
+	}
+	return name
+}
+
+// deriveFilename names a file after its Content-Disposition or else the last
+// segment of the URL it came from, adding the extension of its detected type
+// if the name has none.
+func deriveFilename(disposition, rawURL, detected string) string {
+	var name string
+	if _, params, err := mime.ParseMediaType(disposition); err == nil {
+		name = safeFilename(params["filename"])
+	}
+	if name == "" {
+		if u, err := url.Parse(rawURL); err == nil {
+			name = safeFilename(u.Path)
+		}
+	}
+	if name == "" {
+		name = defaultFilename
+	}
+
+	if path.Ext(name) == "" {
+		mediaType, _, _ := mime.ParseMediaType(detected)
+		for _, sig := range signatures {
+			if sig.mimeType == mediaType {
+				name += sig.ext
+				break
+			}
+		}
+	}
+	return name
+}
+
+// describeFile returns the types and the name of a file about to be stored,
+// whose first bytes are head. The type declared by the upstream wins unless it
+// is generic.
+func describeFile(head []byte, declared, disposition, rawURL string) entity.FileMetadata {
+	detected := detectMimeType(head)
+	mimeType := declared
+	if isGenericMimeType(declared) {
+		mimeType = detected
+	}
+	return entity.FileMetadata{
+		MimeType:         mimeType,
+		DeclaredMimeType: declared,
+		DetectedMimeType: detected,
+		Filename:         deriveFilename(disposition, rawURL, detected),
+	}
+}
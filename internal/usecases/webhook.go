@@ -0,0 +1,188 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"gin-quickstart/internal/domain/entity"
+	"gin-quickstart/internal/infra/fetcher"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	webhookTimeout = 10 * time.Second
+
+	// HeaderWebhookTimestamp carries the unix time a delivery was signed at;
+	// receivers should reject old timestamps to prevent replays.
+	HeaderWebhookTimestamp = "X-Webhook-Timestamp"
+	// HeaderWebhookSignature is "sha256=" followed by the hex HMAC-SHA256 of
+	// the timestamp, a dot and the body, keyed with the callback secret.
+	HeaderWebhookSignature = "X-Webhook-Signature"
+)
+
+var DefaultWebhookRetryPolicy = entity.RetryPolicy{
+	MaxAttempts:          6,
+	BaseBackoff:          time.Second,
+	MaxBackoff:           5 * time.Minute,
+	Jitter:               jitter(0.2),
+	RetryableErrors:      DefaultRetryPolicy.RetryableErrors,
+	RetryableStatusCodes: DefaultRetryPolicy.RetryableStatusCodes,
+}
+
+// WithWebhookRetryPolicy sets how failed callback deliveries are retried.
+func WithWebhookRetryPolicy(policy entity.RetryPolicy) Option {
+	return func(u *DownloadUseCase) {
+		u.webhookPolicy = policy
+	}
+}
+
+type webhookFileError struct {
+	Code string `json:"code"`
+}
+
+type webhookFile struct {
+	URL           string            `json:"url"`
+	State         string            `json:"state"`
+	FileID        string            `json:"file_id,omitempty"`
+	SHA256        string            `json:"sha256,omitempty"`
+	Error         *webhookFileError `json:"error,omitempty"`
+	BytesReceived int64             `json:"bytes_received"`
+	BytesTotal    int64             `json:"bytes_total"`
+}
+
+// webhookPayload is the job document posted to callbacks.
+type webhookPayload struct {
+	ID        string        `json:"id"`
+	Status    string        `json:"status"`
+	CreatedAt time.Time     `json:"created_at"`
+	Files     []webhookFile `json:"files"`
+}
+
+func newWebhookPayload(job entity.DownloadJob) webhookPayload {
+	payload := webhookPayload{
+		ID:        job.ID,
+		Status:    job.Status.String(),
+		CreatedAt: job.CreatedAt,
+		Files:     make([]webhookFile, len(job.Items)),
+	}
+	for i, item := range job.Items {
+		file := webhookFile{
+			URL:           RedactURL(item.URL),
+			State:         item.State.String(),
+			FileID:        item.FileID,
+			SHA256:        item.SHA256,
+			BytesReceived: item.BytesReceived,
+			BytesTotal:    item.BytesTotal,
+		}
+		if item.Error != nil {
+			file.Error = &webhookFileError{Code: string(item.Error.Code)}
+		}
+		payload.Files[i] = file
+	}
+	return payload
+}
+
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookLog keeps the deliveries of every job, apart from the jobs
+// themselves so a delivery never overwrites a job that was resumed meanwhile.
+type webhookLog struct {
+	mu         sync.Mutex
+	deliveries map[string][]entity.WebhookDelivery
+}
+
+func newWebhookLog() *webhookLog {
+	return &webhookLog{deliveries: make(map[string][]entity.WebhookDelivery)}
+}
+
+func (l *webhookLog) add(jobID string, d entity.WebhookDelivery) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.deliveries[jobID] = append(l.deliveries[jobID], d)
+}
+
+func (l *webhookLog) get(jobID string) []entity.WebhookDelivery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]entity.WebhookDelivery(nil), l.deliveries[jobID]...)
+}
+
+// notify posts a job that has ended to its callback, retrying failed
+// deliveries with backoff. Every attempt is logged with the job.
+func (u *DownloadUseCase) notify(job entity.DownloadJob) {
+	cb := job.Options.Callback
+	if cb == nil || cb.URL == "" || !job.Status.Terminal() {
+		return
+	}
+
+	body, err := json.Marshal(newWebhookPayload(job))
+	if err != nil {
+		slog.Warn("encoding webhook failed", "job_id", job.ID, "error", err)
+		return
+	}
+
+	policy := u.webhookPolicy
+	for attempt := 1; ; attempt++ {
+		d := entity.WebhookDelivery{At: time.Now(), JobStatus: job.Status}
+
+		d.StatusCode, err = u.deliverWebhook(cb, body)
+		if err != nil {
+			d.Error = err.Error()
+		}
+		u.webhooks.add(job.ID, d)
+
+		if err == nil {
+			return
+		}
+		if attempt >= policy.MaxAttempts || !isRetryable(policy, err) {
+			slog.Warn("webhook delivery failed", "job_id", job.ID, "url", RedactURL(cb.URL), "attempts", attempt, "error", err)
+			return
+		}
+		time.Sleep(backoff(policy, attempt, err))
+	}
+}
+
+func (u *DownloadUseCase) deliverWebhook(cb *entity.Callback, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cb.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookTimestamp, timestamp)
+	if cb.Secret != "" {
+		req.Header.Set(HeaderWebhookSignature, signWebhook(cb.Secret.Reveal(), timestamp, body))
+	}
+
+	resp, err := u.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fetcher.NewUpstreamError(resp)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,139 @@
+package bandwidth
+
+import (
+	"context"
+	"io"
+	"slices"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+const minBurst = 32 << 10 // 32kb
+
+// Limiter divides a global bytes per second ceiling between its shares. Every
+// share gets an equal part, capped by its own maximum; what a capped share
+// leaves unused is split among the others. A zero ceiling means unlimited.
+type Limiter struct {
+	mu      sync.Mutex
+	ceiling int64
+	shares  []*Share
+}
+
+func NewLimiter(bytesPerSec int64) *Limiter {
+	return &Limiter{ceiling: bytesPerSec}
+}
+
+// Share is the part of the bandwidth one consumer, such as a job, may use.
+type Share struct {
+	limiter *Limiter
+	max     int64
+	rate    *rate.Limiter
+	meter   *Meter
+}
+
+// Join adds a share capped at maxBytesPerSec, or only by the ceiling when it is zero.
+func (l *Limiter) Join(maxBytesPerSec int64) *Share {
+	s := &Share{
+		limiter: l,
+		max:     maxBytesPerSec,
+		rate:    rate.NewLimiter(rate.Inf, minBurst),
+		meter:   NewMeter(),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.shares = append(l.shares, s)
+	l.rebalance()
+	return s
+}
+
+// Leave returns the bandwidth of s to the other shares.
+func (s *Share) Leave() {
+	l := s.limiter
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.shares = slices.DeleteFunc(l.shares, func(other *Share) bool { return other == s })
+	l.rebalance()
+}
+
+func (l *Limiter) rebalance() {
+	byMax := slices.Clone(l.shares)
+	slices.SortFunc(byMax, func(a, b *Share) int {
+		return compareMax(a.max, b.max)
+	})
+
+	remaining := l.ceiling
+	for i, s := range byMax {
+		limit := s.max
+		if l.ceiling > 0 {
+			// more shares than bytes per second still get a byte each, a zero
+			// limit would leave them unthrottled
+			fair := max(remaining/int64(len(byMax)-i), 1)
+			if limit <= 0 || limit > fair {
+				limit = fair
+			}
+			remaining -= limit
+		}
+		s.setLimit(limit)
+	}
+}
+
+// compareMax orders caps ascending with zero, meaning uncapped, last.
+func compareMax(a, b int64) int {
+	switch {
+	case a == b:
+		return 0
+	case a <= 0:
+		return 1
+	case b <= 0:
+		return -1
+	case a < b:
+		return -1
+	default:
+		return 1
+	}
+}
+
+func (s *Share) setLimit(bytesPerSec int64) {
+	if bytesPerSec <= 0 {
+		s.rate.SetLimit(rate.Inf)
+		return
+	}
+	s.rate.SetBurst(max(int(bytesPerSec), minBurst))
+	s.rate.SetLimit(rate.Limit(bytesPerSec))
+}
+
+// Rate reports the bytes per second currently read through the share.
+func (s *Share) Rate() int64 {
+	return s.meter.Rate()
+}
+
+// Reader throttles r to the share and counts what it reads.
+func (s *Share) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &reader{ctx: ctx, r: r, share: s}
+}
+
+type reader struct {
+	ctx   context.Context
+	r     io.Reader
+	share *Share
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if burst := r.share.rate.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.share.meter.Add(n)
+		if werr := r.share.rate.WaitN(r.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,74 @@
+package bandwidth
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	meterResolution = 250 * time.Millisecond
+	meterWindow     = 3 * time.Second
+)
+
+type sample struct {
+	at    time.Time
+	total int64
+}
+
+// Meter measures throughput over a sliding window of a few seconds.
+type Meter struct {
+	mu      sync.Mutex
+	total   int64
+	samples []sample
+}
+
+func NewMeter() *Meter {
+	return &Meter{samples: []sample{{at: time.Now()}}}
+}
+
+func (m *Meter) Add(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.total += int64(n)
+
+	now := time.Now()
+	if last := m.samples[len(m.samples)-1]; now.Sub(last.at) >= meterResolution {
+		m.samples = append(m.samples, sample{at: now, total: m.total})
+	}
+	m.trim(now)
+}
+
+// Total returns every byte counted so far.
+func (m *Meter) Total() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.total
+}
+
+// Rate returns the bytes per second over the window.
+func (m *Meter) Rate() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.trim(now)
+
+	oldest := m.samples[0]
+	elapsed := now.Sub(oldest.at)
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(m.total-oldest.total) / elapsed.Seconds())
+}
+
+// trim drops the samples that fell out of the window, keeping the newest of
+// them as the baseline the rate is measured from.
+func (m *Meter) trim(now time.Time) {
+	i := 0
+	for i < len(m.samples)-1 && now.Sub(m.samples[i+1].at) >= meterWindow {
+		i++
+	}
+	m.samples = m.samples[i:]
+}
@@ -0,0 +1,106 @@
+package egress
+
+import (
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Rule sends the hosts matching Pattern, a path.Match glob such as
+// "*.partner.com", through Proxy. A nil Proxy connects directly.
+type Rule struct {
+	Pattern string
+	Proxy   *url.URL
+}
+
+func (r Rule) matches(host string) bool {
+	ok, err := path.Match(strings.ToLower(r.Pattern), host)
+	return err == nil && ok
+}
+
+// Router picks the proxy for a host: hosts excluded by NO_PROXY entries
+// connect directly, then the first matching rule wins, then the default.
+type Router struct {
+	defaultProxy *url.URL
+	rules        []Rule
+	noProxy      []string
+}
+
+type Option func(*Router)
+
+// WithDefaultProxy sets the proxy of the hosts no rule matches. Supported
+// schemes are http, https, socks5 and socks5h.
+func WithDefaultProxy(proxy *url.URL) Option {
+	return func(r *Router) {
+		r.defaultProxy = proxy
+	}
+}
+
+// WithRule adds a rule; rules are matched in the order they are added.
+func WithRule(pattern string, proxy *url.URL) Option {
+	return func(r *Router) {
+		r.rules = append(r.rules, Rule{Pattern: pattern, Proxy: proxy})
+	}
+}
+
+// WithNoProxy excludes hosts the way NO_PROXY does: "*" excludes every host,
+// a CIDR the address literals in it, and a domain the domain itself and its
+// subdomains, with or without a leading dot.
+func WithNoProxy(entries ...string) Option {
+	return func(r *Router) {
+		for _, entry := range entries {
+			if entry = strings.ToLower(strings.TrimSpace(entry)); entry != "" {
+				r.noProxy = append(r.noProxy, entry)
+			}
+		}
+	}
+}
+
+func New(options ...Option) *Router {
+	r := &Router{}
+
+	for _, opt := range options {
+		opt(r)
+	}
+
+	return r
+}
+
+// ProxyFor returns the proxy to reach host through, or nil to connect directly.
+func (r *Router) ProxyFor(host string) *url.URL {
+	if r == nil {
+		return nil
+	}
+
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	if r.excluded(host) {
+		return nil
+	}
+	for _, rule := range r.rules {
+		if rule.matches(host) {
+			return rule.Proxy
+		}
+	}
+	return r.defaultProxy
+}
+
+func (r *Router) excluded(host string) bool {
+	ip, ipErr := netip.ParseAddr(host)
+	for _, entry := range r.noProxy {
+		if entry == "*" {
+			return true
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			if ipErr == nil && prefix.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		domain := strings.TrimPrefix(entry, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package hostlimiter
+
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
+)
+
+// Limit caps the outbound traffic to the hosts matching Pattern, a path.Match
+// glob such as "*.example.com". Zero values mean unlimited.
+type Limit struct {
+	Pattern           string
+	MaxConns          int
+	RequestsPerSecond float64
+	Burst             int
+}
+
+func (l Limit) matches(host string) bool {
+	ok, err := path.Match(l.Pattern, host)
+	return err == nil && ok
+}
+
+type hostState struct {
+	conns *semaphore.Weighted
+	rate  *rate.Limiter
+	// users counts the Acquire calls holding or waiting on the state, guarded
+	// by the mutex of the Limiter.
+	users int
+}
+
+// idle reports whether dropping the state loses nothing: no one uses it and
+// its rate limiter has refilled, so a new state would behave the same.
+func (st *hostState) idle() bool {
+	if st.users > 0 {
+		return false
+	}
+	return st.rate == nil || st.rate.Tokens() >= float64(st.rate.Burst())
+}
+
+// Limiter schedules outbound connections: every host gets its own connection
+// and request rate budget, and all hosts together share a global connection cap.
+type Limiter struct {
+	global       *semaphore.Weighted
+	defaultLimit Limit
+	limits       []Limit
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+type Option func(*Limiter)
+
+func WithGlobalMaxConns(n int) Option {
+	return func(l *Limiter) {
+		if n > 0 {
+			l.global = semaphore.NewWeighted(int64(n))
+		}
+	}
+}
+
+// WithDefaultLimit applies to hosts no WithHostLimit pattern matches.
+func WithDefaultLimit(limit Limit) Option {
+	return func(l *Limiter) {
+		l.defaultLimit = limit
+	}
+}
+
+// WithHostLimit adds a limit for a host pattern; the first matching pattern wins.
+func WithHostLimit(limit Limit) Option {
+	return func(l *Limiter) {
+		l.limits = append(l.limits, limit)
+	}
+}
+
+func New(options ...Option) *Limiter {
+	l := &Limiter{hosts: make(map[string]*hostState)}
+
+	for _, opt := range options {
+		opt(l)
+	}
+
+	return l
+}
+
+func (l *Limiter) limitFor(host string) Limit {
+	for _, limit := range l.limits {
+		if limit.matches(host) {
+			return limit
+		}
+	}
+	return l.defaultLimit
+}
+
+// state returns the state of host, counting the caller as one more user until
+// it calls done.
+func (l *Limiter) state(host string) *hostState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if st, exists := l.hosts[host]; exists {
+		st.users++
+		return st
+	}
+
+	// states left behind by a rate limited host are dropped once refilled
+	for h, st := range l.hosts {
+		if st.idle() {
+			delete(l.hosts, h)
+		}
+	}
+
+	limit := l.limitFor(host)
+	st := &hostState{}
+	if limit.MaxConns > 0 {
+		st.conns = semaphore.NewWeighted(int64(limit.MaxConns))
+	}
+	if limit.RequestsPerSecond > 0 {
+		st.rate = rate.NewLimiter(rate.Limit(limit.RequestsPerSecond), max(limit.Burst, 1))
+	}
+	st.users++
+	l.hosts[host] = st
+	return st
+}
+
+// done drops the caller from the users of the state of host, and the state
+// itself once idle.
+func (l *Limiter) done(host string, st *hostState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st.users--
+	if st.idle() && l.hosts[host] == st {
+		delete(l.hosts, host)
+	}
+}
+
+// Acquire blocks until a new request to host is allowed. The returned release
+// must be called once the connection is no longer used.
+func (l *Limiter) Acquire(ctx context.Context, host string) (func(), error) {
+	host = strings.ToLower(host)
+	st := l.state(host)
+
+	if st.conns != nil {
+		if err := st.conns.Acquire(ctx, 1); err != nil {
+			l.done(host, st)
+			return nil, err
+		}
+	}
+	if l.global != nil {
+		if err := l.global.Acquire(ctx, 1); err != nil {
+			if st.conns != nil {
+				st.conns.Release(1)
+			}
+			l.done(host, st)
+			return nil, err
+		}
+	}
+
+	release := func() {
+		if l.global != nil {
+			l.global.Release(1)
+		}
+		if st.conns != nil {
+			st.conns.Release(1)
+		}
+		l.done(host, st)
+	}
+
+	if st.rate != nil {
+		if err := st.rate.Wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	var once sync.Once
+	return func() { once.Do(release) }, nil
+}
@@ -0,0 +1,233 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrBlocked is wrapped by every error of a connection the guard refused.
+var ErrBlocked = errors.New("destination is blocked")
+
+// reservedNets are the ranges blocked by default that netip has no predicate
+// for. Shared address space hosts cloud metadata services too, such as
+// 100.100.100.200.
+var reservedNets = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // this network
+	netip.MustParsePrefix("100.64.0.0/10"), // shared address space
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+}
+
+// nat64Net holds the IPv6 addresses a NAT64 gateway translates to the IPv4
+// address in their last 32 bits.
+var nat64Net = netip.MustParsePrefix("64:ff9b::/96")
+
+// Guard decides which destinations outbound requests may reach. Loopback,
+// link-local, private, shared, multicast, unspecified and other reserved
+// addresses are blocked unless an allowlist lets them through; denylists
+// block any other destination. IPv4-mapped and NAT64 addresses are checked
+// as the IPv4 address they reach.
+//
+// Hosts are path.Match globs such as "*.internal". A denied host or network
+// wins over an allowed one, and an allowed host over the default ranges.
+type Guard struct {
+	allowHosts []string
+	denyHosts  []string
+	allowNets  []netip.Prefix
+	denyNets   []netip.Prefix
+
+	resolver *net.Resolver
+	dialer   *net.Dialer
+}
+
+type Option func(*Guard)
+
+// WithAllowHosts lets the hosts matching patterns reach any address.
+func WithAllowHosts(patterns ...string) Option {
+	return func(g *Guard) {
+		g.allowHosts = append(g.allowHosts, lowerAll(patterns)...)
+	}
+}
+
+// WithDenyHosts blocks the hosts matching patterns.
+func WithDenyHosts(patterns ...string) Option {
+	return func(g *Guard) {
+		g.denyHosts = append(g.denyHosts, lowerAll(patterns)...)
+	}
+}
+
+// WithAllowCIDRs lets addresses in prefixes through, even in the default ranges.
+func WithAllowCIDRs(prefixes ...netip.Prefix) Option {
+	return func(g *Guard) {
+		g.allowNets = append(g.allowNets, prefixes...)
+	}
+}
+
+// WithDenyCIDRs blocks addresses in prefixes.
+func WithDenyCIDRs(prefixes ...netip.Prefix) Option {
+	return func(g *Guard) {
+		g.denyNets = append(g.denyNets, prefixes...)
+	}
+}
+
+func WithDialer(dialer *net.Dialer) Option {
+	return func(g *Guard) {
+		g.dialer = dialer
+	}
+}
+
+func New(options ...Option) *Guard {
+	g := &Guard{
+		resolver: net.DefaultResolver,
+		dialer:   &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+	}
+
+	for _, opt := range options {
+		opt(g)
+	}
+
+	return g
+}
+
+func lowerAll(patterns []string) []string {
+	lowered := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		lowered[i] = strings.ToLower(pattern)
+	}
+	return lowered
+}
+
+func matchHost(host string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, host); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchNet(ip netip.Addr, prefixes []netip.Prefix) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// internal reports whether ip is in one of the ranges blocked by default.
+func internal(ip netip.Addr) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsPrivate() || ip.IsMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() ||
+		matchNet(ip, reservedNets)
+}
+
+// unwrap returns the IPv4 address an IPv4-mapped or NAT64 address reaches,
+// or ip itself.
+func unwrap(ip netip.Addr) netip.Addr {
+	if nat64Net.Contains(ip) {
+		b := ip.As16()
+		return netip.AddrFrom4([4]byte(b[12:]))
+	}
+	return ip.Unmap()
+}
+
+func blocked(host string) error {
+	return fmt.Errorf("%w: %s", ErrBlocked, host)
+}
+
+// CheckHost rejects a host, a name or an address literal, that is blocked
+// before anything is resolved. A name that passes is checked again against
+// the addresses it resolves to when dialed.
+func (g *Guard) CheckHost(host string) error {
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return g.checkAddr(host, ip)
+	}
+	if matchHost(host, g.denyHosts) {
+		return blocked(host)
+	}
+	return nil
+}
+
+func (g *Guard) checkAddr(host string, ip netip.Addr) error {
+	ip = unwrap(ip)
+	switch {
+	case matchHost(host, g.denyHosts), matchNet(ip, g.denyNets):
+		return blocked(host)
+	case matchHost(host, g.allowHosts), matchNet(ip, g.allowNets):
+		return nil
+	case internal(ip):
+		return blocked(host)
+	}
+	return nil
+}
+
+// DialContext resolves address and connects to the first address allowed for
+// its host. It dials the checked address itself, so a name rebound to another
+// address between check and connect cannot get around the guard.
+func (g *Guard) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	host = strings.ToLower(host)
+	if err := g.CheckHost(host); err != nil {
+		return nil, err
+	}
+
+	ips, err := g.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	err = blocked(host)
+	for _, ip := range ips {
+		if checkErr := g.checkAddr(host, ip); checkErr != nil {
+			continue
+		}
+		var conn net.Conn
+		conn, err = g.dialer.DialContext(ctx, network, net.JoinHostPort(ip.Unmap().String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func (g *Guard) Dial(network, address string) (net.Conn, error) {
+	return g.DialContext(context.Background(), network, address)
+}
+
+// CheckRedirect is an http.Client CheckRedirect that checks every hop and
+// keeps the default limit of 10 redirects.
+func (g *Guard) CheckRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return g.CheckURL(req.URL)
+}
+
+// CheckURL rejects a URL that is not http or https or whose host is blocked.
+func (g *Guard) CheckURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return blocked(u.Redacted())
+	}
+	return g.CheckHost(u.Hostname())
+}
+
+// Transport returns an http.Transport that only connects through the guard.
+// Proxies from the environment are ignored since they would connect instead.
+func (g *Guard) Transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = g.DialContext
+	return transport
+}
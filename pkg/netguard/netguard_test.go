@@ -0,0 +1,61 @@
+package netguard
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestGuard_CheckAddr(t *testing.T) {
+	guard := New(
+		WithAllowCIDRs(netip.MustParsePrefix("10.1.0.0/16")),
+		WithDenyCIDRs(netip.MustParsePrefix("203.0.113.0/24")),
+	)
+
+	tests := []struct {
+		addr    string
+		blocked bool
+	}{
+		{"93.184.216.34", false},
+		{"2606:2800:220:1:248:1893:25c8:1946", false},
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"10.0.0.1", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"fc00::1", true},
+		{"224.0.0.1", true},
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"100.64.0.1", true},
+		{"100.100.100.200", true},
+		{"100.127.255.255", true},
+		{"100.128.0.1", false},
+		{"198.18.0.1", true},
+		{"198.19.255.255", true},
+		{"198.20.0.1", false},
+		// IPv4-mapped and NAT64 addresses reach the IPv4 address they embed
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:93.184.216.34", false},
+		{"64:ff9b::7f00:1", true},
+		{"64:ff9b::169.254.169.254", true},
+		{"64:ff9b::100.100.100.200", true},
+		{"64:ff9b::93.184.216.34", false},
+		// the lists apply to the embedded address as well
+		{"10.1.2.3", false},
+		{"64:ff9b::10.1.2.3", false},
+		{"203.0.113.7", true},
+		{"64:ff9b::203.0.113.7", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			err := guard.checkAddr(tt.addr, netip.MustParseAddr(tt.addr))
+			if blocked := errors.Is(err, ErrBlocked); blocked != tt.blocked {
+				t.Fatalf("expected blocked %v, got %v", tt.blocked, err)
+			}
+		})
+	}
+}